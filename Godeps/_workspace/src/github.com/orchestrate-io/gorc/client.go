@@ -11,12 +11,14 @@
 package gorc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -68,6 +70,33 @@ var (
 type Client struct {
 	httpClient *http.Client
 	authToken  string
+	baseURL    string
+	userAgent  string
+	retrier    *retrier
+}
+
+// Options that control how a Client talks to Orchestrate. Fields left at their
+// zero value select the defaults.
+type ClientOptions struct {
+	// The root URL of the API, including the version path. If empty then
+	// https://api.orchestrate.io/v0/ is used.
+	BaseURL string
+
+	// The RoundTripper used to execute requests. If nil then
+	// DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// The User-Agent header sent with every request. If empty then the
+	// net/http default is sent.
+	UserAgent string
+
+	// The time limit for each call, including reading the response body.
+	// Zero means no limit beyond those imposed by the Transport.
+	Timeout time.Duration
+
+	// How failed calls are retried. If nil then every call is made exactly
+	// once. The timeout applies to each attempt separately.
+	Retry *RetryPolicy
 }
 
 // An implementation of 'error' that exposes all the orchestrate specific
@@ -100,15 +129,54 @@ func NewClient(authToken string) *Client {
 // Like NewClient, except that it allows a specific http.Transport to be
 // provided for use, rather than DefaultTransport.
 func NewClientWithTransport(authToken string, transport *http.Transport) *Client {
-	return &Client{
-		httpClient: &http.Client{Transport: transport},
-		authToken:  authToken,
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	return NewClientWithOptions(authToken, &ClientOptions{Transport: transport})
+}
+
+// Like NewClient, except that the API location, transport, user agent and
+// timeout can be configured. A nil options value selects all the defaults.
+func NewClientWithOptions(authToken string, options *ClientOptions) *Client {
+	if options == nil {
+		options = &ClientOptions{}
+	}
+
+	baseURL := options.BaseURL
+	if baseURL == "" {
+		baseURL = rootUri
+	} else if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
 	}
+
+	var transport http.RoundTripper = DefaultTransport
+	if options.Transport != nil {
+		transport = options.Transport
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+		},
+		authToken: authToken,
+		baseURL:   baseURL,
+		userAgent: options.UserAgent,
+	}
+	if options.Retry != nil {
+		c.retrier = newRetrier(options.Retry)
+	}
+	return c
 }
 
 // Check that Orchestrate is reachable.
 func (c *Client) Ping() error {
-	resp, err := c.doRequest("HEAD", "", nil, nil)
+	return c.PingContext(context.Background())
+}
+
+// Like Ping, but the call is abandoned when ctx is done.
+func (c *Client) PingContext(ctx context.Context) error {
+	resp, err := c.doRequest(ctx, "HEAD", "", nil, nil)
 	if err != nil {
 		return err
 	}
@@ -141,15 +209,19 @@ func (e OrchestrateError) Error() string {
 	return fmt.Sprintf("%s (%d): %s", e.Status, e.StatusCode, e.Message)
 }
 
-// Executes an HTTP request.
-func (c *Client) doRequest(method, trailing string, headers map[string]string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, rootUri+trailing, body)
+// Executes an HTTP request. The request is canceled when ctx is done.
+func (c *Client) doRequest(ctx context.Context, method, trailing string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+trailing, body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.SetBasicAuth(c.authToken, "")
 
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
 	for k, v := range headers {
 		req.Header.Add(k, v)
 	}
@@ -158,5 +230,8 @@ func (c *Client) doRequest(method, trailing string, headers map[string]string, b
 		req.Header.Add("Content-Type", "application/json")
 	}
 
+	if c.retrier != nil && c.retrier.retryable(req) {
+		return c.retrier.do(c.httpClient, req)
+	}
 	return c.httpClient.Do(req)
 }
@@ -0,0 +1,104 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	count int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.count++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	var path, userAgent, user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		userAgent = r.Header.Get("User-Agent")
+		user, _, _ = r.BasicAuth()
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	c := NewClientWithOptions("secret", &ClientOptions{
+		BaseURL:   server.URL + "/v0",
+		Transport: transport,
+		UserAgent: "gorc-test/1.0",
+	})
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v0/" {
+		t.Errorf("expected request to /v0/, got %q", path)
+	}
+	if userAgent != "gorc-test/1.0" {
+		t.Errorf("expected custom user agent, got %q", userAgent)
+	}
+	if user != "secret" {
+		t.Errorf("expected auth token as the user name, got %q", user)
+	}
+	if transport.count != 1 {
+		t.Errorf("expected the custom transport to be used once, got %d", transport.count)
+	}
+}
+
+func TestClientDefaultOptions(t *testing.T) {
+	c := NewClientWithOptions("secret", nil)
+	if c.baseURL != rootUri {
+		t.Errorf("expected default base URL, got %q", c.baseURL)
+	}
+	if c.httpClient.Transport != DefaultTransport {
+		t.Error("expected the default transport")
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewClientWithOptions("secret", &ClientOptions{BaseURL: server.URL + "/v0/"})
+	for name, call := range map[string]func(ctx context.Context) error{
+		"search": func(ctx context.Context) error { _, err := c.SearchContext(ctx, "people", "*", 10, 0); return err },
+		"get":    func(ctx context.Context) error { _, err := c.GetContext(ctx, "people", "ada"); return err },
+		"list":   func(ctx context.Context) error { _, err := c.ListContext(ctx, "people", 10); return err },
+		"events": func(ctx context.Context) error {
+			_, err := c.GetEventsContext(ctx, "people", "ada", "visit")
+			return err
+		},
+		"relations": func(ctx context.Context) error {
+			_, err := c.GetRelationsContext(ctx, "people", "ada", []string{"knows"})
+			return err
+		},
+		"put": func(ctx context.Context) error {
+			_, err := c.PutContext(ctx, "people", "ada", map[string]string{})
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := call(ctx)
+		cancel()
+
+		if e, ok := err.(*url.Error); !ok || e.Err != context.DeadlineExceeded || !e.Timeout() {
+			t.Errorf("%s: expected the deadline to abandon the call, got %v", name, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package gorc
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/url"
@@ -24,19 +25,34 @@ type Event struct {
 
 // Get latest events of a particular type from specified collection-key pair.
 func (c *Client) GetEvents(collection, key, kind string) (*EventResults, error) {
+	return c.GetEventsContext(context.Background(), collection, key, kind)
+}
+
+// Like GetEvents, but the call is abandoned when ctx is done.
+func (c *Client) GetEventsContext(ctx context.Context, collection, key, kind string) (*EventResults, error) {
 	trailingUri := collection + "/" + key + "/events/" + kind
 
-	return c.doGetEvents(trailingUri)
+	return c.doGetEvents(ctx, trailingUri)
 }
 
 // Get all events of a particular type from specified collection-key pair in a
 // range.
 func (c *Client) GetEventsInRange(collection, key, kind string, start int64, end int64) (*EventResults, error) {
-	return c.GetEventsInRangeWithLimit(collection, key, kind, start, end, 10)
+	return c.GetEventsInRangeContext(context.Background(), collection, key, kind, start, end)
+}
+
+// Like GetEventsInRange, but the call is abandoned when ctx is done.
+func (c *Client) GetEventsInRangeContext(ctx context.Context, collection, key, kind string, start int64, end int64) (*EventResults, error) {
+	return c.GetEventsInRangeWithLimitContext(ctx, collection, key, kind, start, end, 10)
 }
 
 // Get all events of a particular type from a specified collection-key in a range with a limit
 func (c *Client) GetEventsInRangeWithLimit(collection, key, kind string, start, end, limit int64) (*EventResults, error) {
+	return c.GetEventsInRangeWithLimitContext(context.Background(), collection, key, kind, start, end, limit)
+}
+
+// Like GetEventsInRangeWithLimit, but the call is abandoned when ctx is done.
+func (c *Client) GetEventsInRangeWithLimitContext(ctx context.Context, collection, key, kind string, start, end, limit int64) (*EventResults, error) {
 	queryVariables := url.Values{
 		"start": []string{strconv.FormatInt(start, 10)},
 		"end":   []string{strconv.FormatInt(end, 10)},
@@ -45,50 +61,70 @@ func (c *Client) GetEventsInRangeWithLimit(collection, key, kind string, start,
 
 	trailingUri := collection + "/" + key + "/events/" + kind + "?" + queryVariables.Encode()
 
-	return c.doGetEvents(trailingUri)
+	return c.doGetEvents(ctx, trailingUri)
 }
 
 
 // Put an event of the specified type to provided collection-key pair.
 func (c *Client) PutEvent(collection, key, kind string, value interface{}) error {
+	return c.PutEventContext(context.Background(), collection, key, kind, value)
+}
+
+// Like PutEvent, but the call is abandoned when ctx is done.
+func (c *Client) PutEventContext(ctx context.Context, collection, key, kind string, value interface{}) error {
 	reader, writer := io.Pipe()
 	encoder := json.NewEncoder(writer)
 
 	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutEventRaw(collection, key, kind, reader)
+	return c.PutEventRawContext(ctx, collection, key, kind, reader)
 }
 
 // Put an event of the specified type to provided collection-key pair.
 func (c *Client) PutEventRaw(collection, key, kind string, value io.Reader) error {
+	return c.PutEventRawContext(context.Background(), collection, key, kind, value)
+}
+
+// Like PutEventRaw, but the call is abandoned when ctx is done.
+func (c *Client) PutEventRawContext(ctx context.Context, collection, key, kind string, value io.Reader) error {
 	trailingUri := collection + "/" + key + "/events/" + kind
 
-	return c.doPutEvent(trailingUri, value)
+	return c.doPutEvent(ctx, trailingUri, value)
 
 }
 
 // Put an event of the specified type to provided collection-key pair and time.
 func (c *Client) PutEventWithTime(collection, key, kind string, time int64, value interface{}) error {
+	return c.PutEventWithTimeContext(context.Background(), collection, key, kind, time, value)
+}
+
+// Like PutEventWithTime, but the call is abandoned when ctx is done.
+func (c *Client) PutEventWithTimeContext(ctx context.Context, collection, key, kind string, time int64, value interface{}) error {
 	reader, writer := io.Pipe()
 	encoder := json.NewEncoder(writer)
 
 	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutEventWithTimeRaw(collection, key, kind, time, reader)
+	return c.PutEventWithTimeRawContext(ctx, collection, key, kind, time, reader)
 }
 
 // Put an event of the specified type to provided collection-key pair and time.
 func (c *Client) PutEventWithTimeRaw(collection, key, kind string, time int64, value io.Reader) error {
+	return c.PutEventWithTimeRawContext(context.Background(), collection, key, kind, time, value)
+}
+
+// Like PutEventWithTimeRaw, but the call is abandoned when ctx is done.
+func (c *Client) PutEventWithTimeRawContext(ctx context.Context, collection, key, kind string, time int64, value io.Reader) error {
 	queryVariables := url.Values{
 		"timestamp": []string{strconv.FormatInt(time, 10)},
 	}
 
 	trailingUri := collection + "/" + key + "/events/" + kind + "?" + queryVariables.Encode()
 
-	return c.doPutEvent(trailingUri, value)
+	return c.doPutEvent(ctx, trailingUri, value)
 }
 
 // Execute event get.
-func (c *Client) doGetEvents(trailingUri string) (*EventResults, error) {
-	resp, err := c.doRequest("GET", trailingUri, nil, nil)
+func (c *Client) doGetEvents(ctx context.Context, trailingUri string) (*EventResults, error) {
+	resp, err := c.doRequest(ctx, "GET", trailingUri, nil, nil)
 
 	if err != nil {
 		return nil, err
@@ -110,8 +146,8 @@ func (c *Client) doGetEvents(trailingUri string) (*EventResults, error) {
 }
 
 // Execute event put.
-func (c *Client) doPutEvent(trailingUri string, value io.Reader) error {
-	resp, err := c.doRequest("PUT", trailingUri, nil, value)
+func (c *Client) doPutEvent(ctx context.Context, trailingUri string, value io.Reader) error {
+	resp, err := c.doRequest(ctx, "PUT", trailingUri, nil, value)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,645 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A parsed search query. Only a subset of the Lucene query parser syntax is
+// supported:
+//
+//	terms              foo
+//	fields             name:foo, address.city:paris, name:(foo bar)
+//	boolean operators  AND, OR, NOT, &&, ||, !, +, -
+//	grouping           (foo OR bar) AND baz
+//	phrases            "foo bar"
+//	ranges             age:[18 TO 65], name:{a TO m}, age:[18 TO *]
+//	wildcards          fo*, f?o
+//	match all          *, *:*
+//
+// As with Lucene the implicit operator between clauses is OR. Terms are
+// matched case-insensitively against the words of string values; numbers and
+// booleans are matched against their literal value.
+type Query struct {
+	root node
+}
+
+// Parses a search query.
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseBool("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{root: root}, nil
+}
+
+// Reports whether a JSON document matches the query.
+func (q *Query) Match(value json.RawMessage) bool {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return false
+	}
+
+	return q.root.match(doc)
+}
+
+// A node of the parsed query tree.
+type node interface {
+	match(doc interface{}) bool
+}
+
+// How a clause contributes to its boolean query.
+type occur int
+
+const (
+	should occur = iota
+	must
+	mustNot
+)
+
+// A boolean combination of clauses, with Lucene's SHOULD/MUST/MUST_NOT
+// semantics.
+type boolNode struct {
+	clauses []clause
+}
+
+type clause struct {
+	occur occur
+	node  node
+}
+
+func (n *boolNode) match(doc interface{}) bool {
+	required, optional, optionalMatched := false, 0, false
+	for _, c := range n.clauses {
+		matched := c.node.match(doc)
+		switch c.occur {
+		case must:
+			if !matched {
+				return false
+			}
+			required = true
+		case mustNot:
+			if matched {
+				return false
+			}
+		default:
+			optional++
+			optionalMatched = optionalMatched || matched
+		}
+	}
+
+	if required || optional == 0 {
+		return true
+	}
+	return optionalMatched
+}
+
+// Matches every document.
+type matchAllNode struct{}
+
+func (matchAllNode) match(doc interface{}) bool {
+	return true
+}
+
+// Matches documents where a field holds a term.
+type termNode struct {
+	field string
+	term  string
+}
+
+func (n *termNode) match(doc interface{}) bool {
+	words := tokenize(n.term)
+	for _, leaf := range leaves(doc, n.field) {
+		switch v := leaf.(type) {
+		case string:
+			if matchWords(tokenize(v), words) {
+				return true
+			}
+		default:
+			if literalEqual(v, n.term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches documents where a field holds a sequence of words.
+type phraseNode struct {
+	field string
+	words []string
+}
+
+func (n *phraseNode) match(doc interface{}) bool {
+	for _, leaf := range leaves(doc, n.field) {
+		if s, ok := leaf.(string); ok && matchWords(tokenize(s), n.words) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a sequence of words appears, in order, within another.
+func matchWords(words, sequence []string) bool {
+	if len(sequence) == 0 {
+		return false
+	}
+
+	for i := 0; i+len(sequence) <= len(words); i++ {
+		matched := true
+		for j, word := range sequence {
+			if words[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches documents where a field holds a word matching a pattern, where *
+// matches any run of characters and ? matches a single character.
+type wildcardNode struct {
+	field   string
+	pattern string
+}
+
+func (n *wildcardNode) match(doc interface{}) bool {
+	pattern := []rune(strings.ToLower(n.pattern))
+	for _, leaf := range leaves(doc, n.field) {
+		var words []string
+		switch v := leaf.(type) {
+		case string:
+			words = tokenize(v)
+		default:
+			words = []string{literal(v)}
+		}
+		for _, word := range words {
+			if glob(pattern, []rune(word)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches documents where a field holds a value within a range. An empty
+// bound is open. Numbers are compared numerically when both bounds are
+// numeric, everything else is compared as lower case strings.
+type rangeNode struct {
+	field                      string
+	lower, upper               string
+	includeLower, includeUpper bool
+}
+
+func (n *rangeNode) match(doc interface{}) bool {
+	for _, leaf := range leaves(doc, n.field) {
+		if number, ok := leaf.(json.Number); ok {
+			if n.matchNumber(number) {
+				return true
+			}
+			continue
+		}
+		if s, ok := leaf.(string); ok && n.matchString(strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *rangeNode) matchNumber(number json.Number) bool {
+	value, err := number.Float64()
+	if err != nil {
+		return false
+	}
+
+	if n.lower != "" {
+		lower, err := strconv.ParseFloat(n.lower, 64)
+		if err != nil || value < lower || (value == lower && !n.includeLower) {
+			return false
+		}
+	}
+	if n.upper != "" {
+		upper, err := strconv.ParseFloat(n.upper, 64)
+		if err != nil || value > upper || (value == upper && !n.includeUpper) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *rangeNode) matchString(value string) bool {
+	if n.lower != "" {
+		lower := strings.ToLower(n.lower)
+		if value < lower || (value == lower && !n.includeLower) {
+			return false
+		}
+	}
+	if n.upper != "" {
+		upper := strings.ToLower(n.upper)
+		if value > upper || (value == upper && !n.includeUpper) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the scalar values held at a dot separated field path, descending
+// into arrays and nested objects. An empty field selects every value in the
+// document.
+func leaves(doc interface{}, field string) []interface{} {
+	values := []interface{}{doc}
+	if field != "" {
+		for _, name := range strings.Split(field, ".") {
+			var next []interface{}
+			for _, value := range values {
+				next = append(next, children(value, name)...)
+			}
+			values = next
+		}
+	}
+
+	var result []interface{}
+	for _, value := range values {
+		result = appendLeaves(result, value)
+	}
+	return result
+}
+
+// Returns the values of a named member of an object, or of each object in an
+// array.
+func children(value interface{}, name string) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if child, ok := v[name]; ok {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		var result []interface{}
+		for _, element := range v {
+			result = append(result, children(element, name)...)
+		}
+		return result
+	}
+	return nil
+}
+
+func appendLeaves(result []interface{}, value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			result = appendLeaves(result, child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			result = appendLeaves(result, child)
+		}
+	case nil:
+	default:
+		result = append(result, v)
+	}
+	return result
+}
+
+// Splits a string into lower case words.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the literal form of a number or boolean.
+func literal(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// Reports whether a number or boolean equals a query term.
+func literalEqual(value interface{}, term string) bool {
+	if number, ok := value.(json.Number); ok {
+		a, err1 := number.Float64()
+		b, err2 := strconv.ParseFloat(term, 64)
+		return err1 == nil && err2 == nil && a == b
+	}
+	return literal(value) == strings.ToLower(term)
+}
+
+// Matches a string against a wildcard pattern.
+func glob(pattern, s []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if glob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// The kinds of lexical token in a query.
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenRange
+	tokenLParen
+	tokenRParen
+	tokenColon
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenMust
+	tokenMustNot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+
+	// Set for words that hold an unescaped wildcard character.
+	wildcard bool
+
+	// Set for ranges.
+	rng *rangeNode
+}
+
+// Splits a query into tokens.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen})
+			i++
+		case r == ':':
+			tokens = append(tokens, token{kind: tokenColon})
+			i++
+		case r == '+':
+			tokens = append(tokens, token{kind: tokenMust})
+			i++
+		case r == '-' || r == '!':
+			tokens = append(tokens, token{kind: tokenMustNot})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated phrase at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, text: unescape(runes[i+1 : end])})
+			i = end + 1
+		case r == '[' || r == '{':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated range at position %d", i)
+			}
+			rng, err := parseRange(string(runes[i+1:end]), r == '[', runes[end] == ']')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenRange, rng: rng})
+			i = end + 1
+		default:
+			var word []rune
+			wildcard := false
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()":`, runes[i]) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					word = append(word, runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '*' || runes[i] == '?' {
+					wildcard = true
+				}
+				word = append(word, runes[i])
+				i++
+			}
+			tokens = append(tokens, wordToken(string(word), wildcard))
+		}
+	}
+
+	return tokens, nil
+}
+
+// Classifies a word as an operator or a term.
+func wordToken(word string, wildcard bool) token {
+	switch word {
+	case "AND", "&&":
+		return token{kind: tokenAnd}
+	case "OR", "||":
+		return token{kind: tokenOr}
+	case "NOT":
+		return token{kind: tokenNot}
+	}
+	return token{kind: tokenWord, text: word, wildcard: wildcard}
+}
+
+// Parses the body of a range, e.g. "18 TO 65".
+func parseRange(body string, includeLower, includeUpper bool) (*rangeNode, error) {
+	parts := strings.Fields(body)
+	if len(parts) != 3 || parts[1] != "TO" {
+		return nil, fmt.Errorf("invalid range %q", body)
+	}
+
+	rng := &rangeNode{
+		lower:        parts[0],
+		upper:        parts[2],
+		includeLower: includeLower,
+		includeUpper: includeUpper,
+	}
+	if rng.lower == "*" {
+		rng.lower = ""
+	}
+	if rng.upper == "*" {
+		rng.upper = ""
+	}
+	return rng, nil
+}
+
+func unescape(runes []rune) string {
+	var result []rune
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+		}
+		result = append(result, runes[i])
+	}
+	return string(result)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) next() *token {
+	t := p.peek()
+	if t != nil {
+		p.pos++
+	}
+	return t
+}
+
+// Parses a sequence of clauses up to the end of the query, or up to the
+// closing parenthesis of a group. AND marks the clauses on both sides as
+// required, as the classic Lucene parser does.
+func (p *parser) parseBool(field string, group bool) (node, error) {
+	result := &boolNode{}
+
+	for {
+		t := p.peek()
+		if t == nil || (t.kind == tokenRParen && group) {
+			break
+		}
+
+		conjunction := tokenKind(-1)
+		if t.kind == tokenAnd || t.kind == tokenOr {
+			if len(result.clauses) == 0 {
+				return nil, fmt.Errorf("unexpected operator at start of clause list")
+			}
+			conjunction = t.kind
+			p.next()
+		}
+
+		c := clause{occur: should}
+		if conjunction == tokenAnd {
+			c.occur = must
+			if last := &result.clauses[len(result.clauses)-1]; last.occur == should {
+				last.occur = must
+			}
+		}
+
+		if t = p.peek(); t != nil {
+			switch t.kind {
+			case tokenMust:
+				c.occur = must
+				p.next()
+			case tokenMustNot, tokenNot:
+				c.occur = mustNot
+				p.next()
+			}
+		}
+
+		n, err := p.parseClause(field)
+		if err != nil {
+			return nil, err
+		}
+		c.node = n
+		result.clauses = append(result.clauses, c)
+	}
+
+	if len(result.clauses) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	if len(result.clauses) == 1 && result.clauses[0].occur != mustNot {
+		return result.clauses[0].node, nil
+	}
+	return result, nil
+}
+
+// Parses a single clause: a term, phrase, range, field query or group.
+func (p *parser) parseClause(field string) (node, error) {
+	t := p.next()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	switch t.kind {
+	case tokenLParen:
+		n, err := p.parseBool(field, true)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing == nil || closing.kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return n, nil
+	case tokenPhrase:
+		return &phraseNode{field: field, words: tokenize(t.text)}, nil
+	case tokenRange:
+		rng := *t.rng
+		rng.field = field
+		return &rng, nil
+	case tokenWord:
+		if next := p.peek(); next != nil && next.kind == tokenColon {
+			p.next()
+			if t.text == "*" {
+				if value := p.next(); value == nil || value.kind != tokenWord || value.text != "*" {
+					return nil, fmt.Errorf("only *:* may use the * field")
+				}
+				return matchAllNode{}, nil
+			}
+			return p.parseClause(t.text)
+		}
+		if t.text == "*" {
+			return matchAllNode{}, nil
+		}
+		if t.wildcard {
+			return &wildcardNode{field: field, pattern: t.text}, nil
+		}
+		return &termNode{field: field, term: t.text}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected token in query")
+}
@@ -0,0 +1,93 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorctest
+
+import (
+	"testing"
+)
+
+const testDocument = `{
+	"name": "Ada Lovelace",
+	"age": 36,
+	"active": true,
+	"email": "ada@example.com",
+	"bio": "Wrote the first published algorithm",
+	"address": {"city": "London", "country": "UK"},
+	"tags": ["math", "computing"],
+	"born": "1815-12-10"
+}`
+
+func TestQueryMatch(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"*":                               true,
+		"*:*":                             true,
+		"ada":                             true,
+		"ADA":                             true,
+		"babbage":                         false,
+		"name:lovelace":                   true,
+		"email:lovelace":                  false,
+		"address.city:london":             true,
+		"address.city:paris":              false,
+		"address:london":                  true,
+		"tags:math":                       true,
+		"age:36":                          true,
+		"age:36.0":                        true,
+		"active:true":                     true,
+		"ada babbage":                     true,
+		"ada OR babbage":                  true,
+		"ada AND babbage":                 false,
+		"ada && lovelace":                 true,
+		"ada AND NOT babbage":             true,
+		"ada -lovelace":                   false,
+		"+ada babbage":                    true,
+		"!babbage":                        true,
+		"NOT ada":                         false,
+		"(ada OR babbage) AND london":     true,
+		"(ada OR babbage) AND paris":      false,
+		"name:(ada OR charles)":           true,
+		"name:(charles OR babbage)":       false,
+		`"first published"`:               true,
+		`"published first"`:               false,
+		`bio:"first published"`:           true,
+		`name:"first published"`:          false,
+		"age:[18 TO 65]":                  true,
+		"age:[37 TO 65]":                  false,
+		"age:{36 TO 65]":                  false,
+		"age:[36 TO *]":                   true,
+		"age:[* TO 35]":                   false,
+		"born:[1800-01-01 TO 1820-01-01]": true,
+		"name:[m TO z]":                   false,
+		"lov*":                            true,
+		"name:l?velace":                   true,
+		"name:*lace":                      true,
+		"name:x*":                         false,
+		"email:ada@example.com":           true,
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %s", query, err)
+			continue
+		}
+		if matched := q.Match([]byte(testDocument)); matched != expected {
+			t.Errorf("%q matched = %v, expected %v", query, matched, expected)
+		}
+	}
+}
+
+func TestParseQueryInvalid(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"   ",
+		"(ada",
+		"ada)",
+		`"ada`,
+		"age:[18 65]",
+		"age:[18 TO 65",
+		"AND ada",
+		"name:",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("expected %q to be rejected", query)
+		}
+	}
+}
@@ -0,0 +1,737 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+// Package gorctest provides an in-memory stand-in for the Orchestrate API so
+// that code using gorc can be tested without network access or credentials.
+//
+// The server speaks the same REST dialect that gorc uses: search with paging
+// links, key/value get and put with refs and conditional headers, key listing,
+// events and relations. Search queries are evaluated with a small subset of
+// the Lucene syntax; see Query.
+package gorctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orchestrate-io/gorc"
+)
+
+const (
+	// The page size used by search and list when no limit is given.
+	defaultLimit = 10
+
+	// The largest page size accepted by search and list.
+	maxLimit = 100
+)
+
+// An in-memory Orchestrate server.
+type Server struct {
+	*httptest.Server
+
+	// The API key clients must authenticate with. If empty then any key is
+	// accepted.
+	AuthToken string
+
+	// If set, called with each request before it is handled. If it returns
+	// true then it has written a response and the request goes no further.
+	// It can be used to inject failures and latency.
+	Intercept func(w http.ResponseWriter, r *http.Request) bool
+
+	mu          sync.Mutex
+	collections map[string]*collection
+	lastRef     uint64
+	lastOrdinal uint64
+	requests    int
+}
+
+// A collection of items, with the events and relations attached to them.
+type collection struct {
+	items     map[string]*item
+	events    map[string][]*event
+	relations map[string][]relation
+}
+
+// The value stored at a key and its history. A deleted item keeps its history
+// until it is purged.
+type item struct {
+	ref     string
+	value   json.RawMessage
+	history map[string]json.RawMessage
+}
+
+type event struct {
+	key       string
+	kind      string
+	ordinal   uint64
+	timestamp int64
+	value     json.RawMessage
+}
+
+type relation struct {
+	kind       string
+	collection string
+	key        string
+}
+
+// Starts a new, empty server. It should be closed when no longer needed.
+func NewServer() *Server {
+	s := &Server{collections: map[string]*collection{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Returns the API root to configure clients with, including the version path.
+func (s *Server) APIURL() string {
+	return s.URL + "/v0/"
+}
+
+// Returns a gorc client configured to talk to the server.
+func (s *Server) Client() *gorc.Client {
+	return gorc.NewClientWithOptions(s.AuthToken, &gorc.ClientOptions{BaseURL: s.APIURL()})
+}
+
+// Stores a value directly, bypassing HTTP, and returns its ref. It panics if
+// the value can not be encoded as JSON.
+func (s *Server) Put(collection, key string, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store(collection, key, data)
+}
+
+// Returns the number of API requests the server has handled.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+// Removes all data held by the server.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collections = map[string]*collection{}
+}
+
+// An error in the format Orchestrate returns.
+type apiError struct {
+	status  int
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func newError(status int, code, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func errNotFound(path string) *apiError {
+	return newError(404, "items_not_found", "The requested items could not be found: %s", path)
+}
+
+func errBadRequest(format string, args ...interface{}) *apiError {
+	return newError(400, "api_bad_request", format, args...)
+}
+
+// Dispatches a request based on its path below /v0/.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	intercept := s.Intercept
+	s.mu.Unlock()
+
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.AuthToken != "" {
+		if user, _, ok := r.BasicAuth(); !ok || user != s.AuthToken {
+			writeError(w, newError(401, "security_unauthorized", "Valid credentials are required."))
+			return
+		}
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/v0/") {
+		writeError(w, errNotFound(r.URL.Path))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+	params := r.URL.Query()
+
+	var status int
+	var body interface{}
+	var err *apiError
+
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		status, body = 200, nil
+	case len(parts) == 1:
+		status, body, err = s.serveCollection(r, parts[0], params)
+	case len(parts) == 2:
+		status, body, err = s.serveItem(w, r, parts[0], parts[1], params)
+	case len(parts) == 4 && parts[2] == "refs" && r.Method == "GET":
+		status, body, err = s.getRef(w, parts[0], parts[1], parts[3])
+	case len(parts) == 4 && parts[2] == "events":
+		status, body, err = s.serveEvents(r, parts[0], parts[1], parts[3], params)
+	case len(parts) >= 4 && parts[2] == "relations" && r.Method == "GET":
+		status, body, err = s.getRelations(parts[0], parts[1], parts[3:])
+	case len(parts) == 6 && parts[2] == "relation":
+		status, body, err = s.serveRelation(r, parts[0], parts[1], parts[3], parts[4], parts[5])
+	default:
+		err = errNotFound(r.URL.Path)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if raw, ok := body.(json.RawMessage); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(raw)
+		return
+	}
+
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	json.NewEncoder(w).Encode(err)
+}
+
+func (s *Server) serveCollection(r *http.Request, name string, params url.Values) (int, interface{}, *apiError) {
+	switch r.Method {
+	case "GET":
+		if _, ok := params["query"]; ok {
+			return s.search(name, params)
+		}
+		return s.list(name, params)
+	case "DELETE":
+		if params.Get("force") != "true" {
+			return 0, nil, errBadRequest("Deleting a collection requires force=true.")
+		}
+		delete(s.collections, name)
+		return 204, nil, nil
+	}
+	return 0, nil, errMethod(r.Method)
+}
+
+func (s *Server) serveItem(w http.ResponseWriter, r *http.Request, name, key string, params url.Values) (int, interface{}, *apiError) {
+	switch r.Method {
+	case "GET":
+		return s.getRef(w, name, key, "")
+	case "PUT":
+		return s.putItem(w, r, name, key)
+	case "DELETE":
+		return s.deleteItem(r, name, key, params.Get("purge") == "true")
+	}
+	return 0, nil, errMethod(r.Method)
+}
+
+func errMethod(method string) *apiError {
+	return newError(405, "api_bad_request", "The method %s is not supported here.", method)
+}
+
+// Search hits, in the same shape that gorc decodes.
+type searchResults struct {
+	Count      int            `json:"count"`
+	TotalCount int            `json:"total_count"`
+	Results    []searchResult `json:"results"`
+	Next       string         `json:"next,omitempty"`
+	Prev       string         `json:"prev,omitempty"`
+}
+
+type searchResult struct {
+	Path  gorc.Path       `json:"path"`
+	Score float64         `json:"score"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Evaluates a search query. Results are ordered by key.
+func (s *Server) search(name string, params url.Values) (int, interface{}, *apiError) {
+	query, err := ParseQuery(params.Get("query"))
+	if err != nil {
+		return 0, nil, newError(400, "search_query_malformed", "The search query is malformed: %s", err)
+	}
+
+	limit, offset, apiErr := paging(params)
+	if apiErr != nil {
+		return 0, nil, apiErr
+	}
+
+	c := s.collections[name]
+	if c == nil {
+		return 0, nil, errNotFound(name)
+	}
+
+	var hits []searchResult
+	for _, key := range c.keys() {
+		it := c.items[key]
+		if it.value != nil && query.Match(it.value) {
+			hits = append(hits, searchResult{
+				Path:  gorc.Path{Collection: name, Key: key, Ref: it.ref},
+				Score: 1,
+				Value: it.value,
+			})
+		}
+	}
+
+	results := &searchResults{TotalCount: len(hits), Results: []searchResult{}}
+	if offset < len(hits) {
+		end := offset + limit
+		if end > len(hits) {
+			end = len(hits)
+		}
+		results.Results = hits[offset:end]
+	}
+	results.Count = len(results.Results)
+
+	link := func(offset int) string {
+		values := url.Values{
+			"query":  []string{params.Get("query")},
+			"limit":  []string{strconv.Itoa(limit)},
+			"offset": []string{strconv.Itoa(offset)},
+		}
+		return "/v0/" + name + "?" + values.Encode()
+	}
+	if offset+limit < len(hits) {
+		results.Next = link(offset + limit)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		results.Prev = link(prev)
+	}
+
+	return 200, results, nil
+}
+
+// Parses the limit and offset parameters.
+func paging(params url.Values) (int, int, *apiError) {
+	limit, offset := defaultLimit, 0
+
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 || limit > maxLimit {
+			return 0, 0, errBadRequest("The limit must be between 0 and %d.", maxLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errBadRequest("The offset must be a non-negative integer.")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// Key/value list results, in the same shape that gorc decodes.
+type listResults struct {
+	Count   int          `json:"count"`
+	Results []listResult `json:"results"`
+	Next    string       `json:"next,omitempty"`
+}
+
+type listResult struct {
+	Path  gorc.Path       `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Lists items in key order, honouring startKey, afterKey and endKey.
+func (s *Server) list(name string, params url.Values) (int, interface{}, *apiError) {
+	limit, _, err := paging(params)
+	if err != nil {
+		return 0, nil, err
+	}
+	if limit < 1 {
+		return 0, nil, errBadRequest("The limit must be between 1 and %d.", maxLimit)
+	}
+
+	start, after, end := params.Get("startKey"), params.Get("afterKey"), params.Get("endKey")
+	if start != "" && after != "" {
+		return 0, nil, errBadRequest("Only one of startKey and afterKey may be given.")
+	}
+
+	results := &listResults{Results: []listResult{}}
+	if c := s.collections[name]; c != nil {
+		for _, key := range c.keys() {
+			it := c.items[key]
+			if it.value == nil || (start != "" && key < start) || (after != "" && key <= after) {
+				continue
+			}
+			if end != "" && key > end {
+				break
+			}
+			if len(results.Results) == limit {
+				values := url.Values{
+					"limit":    []string{strconv.Itoa(limit)},
+					"afterKey": []string{results.Results[limit-1].Path.Key},
+				}
+				if end != "" {
+					values.Set("endKey", end)
+				}
+				results.Next = "/v0/" + name + "?" + values.Encode()
+				break
+			}
+			results.Results = append(results.Results, listResult{
+				Path:  gorc.Path{Collection: name, Key: key, Ref: it.ref},
+				Value: it.value,
+			})
+		}
+	}
+	results.Count = len(results.Results)
+
+	return 200, results, nil
+}
+
+// Returns the value at a ref, or the current value if ref is empty.
+func (s *Server) getRef(w http.ResponseWriter, name, key, ref string) (int, interface{}, *apiError) {
+	it := s.item(name, key)
+	if it == nil {
+		return 0, nil, errNotFound(name + "/" + key)
+	}
+
+	var value json.RawMessage
+	if ref == "" {
+		ref, value = it.ref, it.value
+	} else {
+		value = it.history[ref]
+	}
+	if value == nil {
+		return 0, nil, errNotFound(name + "/" + key)
+	}
+
+	w.Header().Set("Content-Location", "/v0/"+name+"/"+key+"/refs/"+ref)
+	w.Header().Set("ETag", `"`+ref+`"`)
+	return 200, value, nil
+}
+
+// Stores a value, honouring If-Match and If-None-Match.
+func (s *Server) putItem(w http.ResponseWriter, r *http.Request, name, key string) (int, interface{}, *apiError) {
+	value, apiErr := readValue(r)
+	if apiErr != nil {
+		return 0, nil, apiErr
+	}
+
+	it := s.item(name, key)
+	present := it != nil && it.value != nil
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !present || match != `"`+it.ref+`"` {
+			return 0, nil, newError(412, "item_version_mismatch", "The item has been stored with a different ref.")
+		}
+	}
+	if r.Header.Get("If-None-Match") == `"*"` && present {
+		return 0, nil, newError(412, "item_already_present", "The item is already present.")
+	}
+
+	ref := s.store(name, key, value)
+	w.Header().Set("Location", "/v0/"+name+"/"+key+"/refs/"+ref)
+	w.Header().Set("ETag", `"`+ref+`"`)
+	return 201, nil, nil
+}
+
+// Deletes an item, or purges it and its history.
+func (s *Server) deleteItem(r *http.Request, name, key string, purge bool) (int, interface{}, *apiError) {
+	it := s.item(name, key)
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if it == nil || it.value == nil || match != `"`+it.ref+`"` {
+			return 0, nil, newError(412, "item_version_mismatch", "The item has been stored with a different ref.")
+		}
+	}
+
+	if it != nil {
+		if purge {
+			delete(s.collections[name].items, key)
+		} else {
+			it.value = nil
+		}
+	}
+	return 204, nil, nil
+}
+
+// Event results, in the same shape that gorc decodes.
+type eventResults struct {
+	Count   int           `json:"count"`
+	Results []eventResult `json:"results"`
+}
+
+type eventResult struct {
+	Path      gorc.Path       `json:"path"`
+	Ordinal   uint64          `json:"ordinal"`
+	Timestamp int64           `json:"timestamp"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// Stores or lists the events of a kind attached to an item.
+func (s *Server) serveEvents(r *http.Request, name, key, kind string, params url.Values) (int, interface{}, *apiError) {
+	switch r.Method {
+	case "PUT", "POST":
+		value, err := readValue(r)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+		if v := params.Get("timestamp"); v != "" {
+			var err error
+			if timestamp, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return 0, nil, errBadRequest("The timestamp must be an integer.")
+			}
+		}
+
+		s.lastOrdinal++
+		c := s.collection(name)
+		c.events[key] = append(c.events[key], &event{
+			key:       key,
+			kind:      kind,
+			ordinal:   s.lastOrdinal,
+			timestamp: timestamp,
+			value:     value,
+		})
+		return 204, nil, nil
+	case "GET":
+		return s.getEvents(name, key, kind, params)
+	}
+	return 0, nil, errMethod(r.Method)
+}
+
+// Lists events newest first, within the optional [start, end) range.
+func (s *Server) getEvents(name, key, kind string, params url.Values) (int, interface{}, *apiError) {
+	limit, _, apiErr := paging(params)
+	if apiErr != nil {
+		return 0, nil, apiErr
+	}
+
+	var start, end int64 = 0, 1<<63 - 1
+	var err error
+	if v := params.Get("start"); v != "" {
+		if start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, nil, errBadRequest("The start must be an integer.")
+		}
+	}
+	if v := params.Get("end"); v != "" {
+		if end, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, nil, errBadRequest("The end must be an integer.")
+		}
+	}
+
+	var events []*event
+	if c := s.collections[name]; c != nil {
+		for _, e := range c.events[key] {
+			if e.kind == kind && e.timestamp >= start && e.timestamp < end {
+				events = append(events, e)
+			}
+		}
+	}
+	sort.Sort(byRecency(events))
+
+	results := &eventResults{Results: []eventResult{}}
+	for _, e := range events {
+		if len(results.Results) == limit {
+			break
+		}
+		results.Results = append(results.Results, eventResult{
+			Path:      gorc.Path{Collection: name, Key: key},
+			Ordinal:   e.ordinal,
+			Timestamp: e.timestamp,
+			Value:     e.value,
+		})
+	}
+	results.Count = len(results.Results)
+
+	return 200, results, nil
+}
+
+// Orders events newest first.
+type byRecency []*event
+
+func (e byRecency) Len() int      { return len(e) }
+func (e byRecency) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byRecency) Less(i, j int) bool {
+	if e[i].timestamp != e[j].timestamp {
+		return e[i].timestamp > e[j].timestamp
+	}
+	return e[i].ordinal > e[j].ordinal
+}
+
+// Graph results, in the same shape that gorc decodes.
+type graphResults struct {
+	Count   int           `json:"count"`
+	Results []graphResult `json:"results"`
+}
+
+type graphResult struct {
+	Path  gorc.Path       `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Follows a sequence of relation kinds from an item and returns the items
+// reached by the final hop.
+func (s *Server) getRelations(name, key string, hops []string) (int, interface{}, *apiError) {
+	if s.item(name, key) == nil {
+		return 0, nil, errNotFound(name + "/" + key)
+	}
+
+	frontier := []relation{{collection: name, key: key}}
+	for _, kind := range hops {
+		seen := map[relation]bool{}
+		var next []relation
+		for _, from := range frontier {
+			c := s.collections[from.collection]
+			if c == nil {
+				continue
+			}
+			for _, rel := range c.relations[from.key] {
+				to := relation{collection: rel.collection, key: rel.key}
+				if rel.kind == kind && !seen[to] {
+					seen[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	results := &graphResults{Results: []graphResult{}}
+	for _, to := range frontier {
+		if it := s.item(to.collection, to.key); it != nil && it.value != nil {
+			results.Results = append(results.Results, graphResult{
+				Path:  gorc.Path{Collection: to.collection, Key: to.key, Ref: it.ref},
+				Value: it.value,
+			})
+		}
+	}
+	results.Count = len(results.Results)
+
+	return 200, results, nil
+}
+
+// Creates or deletes a relation between two items.
+func (s *Server) serveRelation(r *http.Request, name, key, kind, sinkName, sinkKey string) (int, interface{}, *apiError) {
+	if s.item(name, key) == nil {
+		return 0, nil, errNotFound(name + "/" + key)
+	}
+	if s.item(sinkName, sinkKey) == nil {
+		return 0, nil, errNotFound(sinkName + "/" + sinkKey)
+	}
+
+	c := s.collections[name]
+	rel := relation{kind: kind, collection: sinkName, key: sinkKey}
+
+	var kept []relation
+	for _, existing := range c.relations[key] {
+		if existing != rel {
+			kept = append(kept, existing)
+		}
+	}
+
+	switch r.Method {
+	case "PUT":
+		c.relations[key] = append(kept, rel)
+	case "DELETE":
+		c.relations[key] = kept
+	default:
+		return 0, nil, errMethod(r.Method)
+	}
+	return 204, nil, nil
+}
+
+// Reads a request body that must hold a JSON object.
+func readValue(r *http.Request) (json.RawMessage, *apiError) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errBadRequest("The request body could not be read: %s", err)
+	}
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil || value == nil {
+		return nil, errBadRequest("The request body must be a JSON object.")
+	}
+
+	return json.RawMessage(bytes.TrimSpace(data)), nil
+}
+
+// Stores a value under a new ref and returns the ref.
+func (s *Server) store(name, key string, value json.RawMessage) string {
+	s.lastRef++
+	ref := fmt.Sprintf("%016x", s.lastRef)
+
+	c := s.collection(name)
+	it := c.items[key]
+	if it == nil {
+		it = &item{history: map[string]json.RawMessage{}}
+		c.items[key] = it
+	}
+	it.ref = ref
+	it.value = value
+	it.history[ref] = value
+
+	return ref
+}
+
+// Returns an item, or nil if it has never been stored.
+func (s *Server) item(name, key string) *item {
+	if c := s.collections[name]; c != nil {
+		return c.items[key]
+	}
+	return nil
+}
+
+// Returns a collection, creating it if needed.
+func (s *Server) collection(name string) *collection {
+	c := s.collections[name]
+	if c == nil {
+		c = &collection{
+			items:     map[string]*item{},
+			events:    map[string][]*event{},
+			relations: map[string][]relation{},
+		}
+		s.collections[name] = c
+	}
+	return c
+}
+
+// Returns the keys of the collection's items in order.
+func (c *collection) keys() []string {
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,258 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorctest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/orchestrate-io/gorc"
+)
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestSearchPaging(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for i := 0; i < 25; i++ {
+		s.Put("people", fmt.Sprintf("p%02d", i), &person{Name: "someone", Age: i})
+	}
+	s.Put("people", "other", &person{Name: "nobody", Age: 99})
+
+	c := s.Client()
+	results, err := c.Search("people", "name:someone", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Count != 10 || results.TotalCount != 25 || results.HasPrev() || !results.HasNext() {
+		t.Fatalf("unexpected first page: %+v", results)
+	}
+
+	seen := int(results.Count)
+	for results.HasNext() {
+		if results, err = c.SearchGetNext(results); err != nil {
+			t.Fatal(err)
+		}
+		if !results.HasPrev() {
+			t.Error("expected later pages to link back")
+		}
+		seen += int(results.Count)
+	}
+	if seen != 25 {
+		t.Errorf("expected to page through 25 results, saw %d", seen)
+	}
+
+	var p person
+	if err := results.Results[len(results.Results)-1].Value(&p); err != nil || p.Age != 24 {
+		t.Errorf("expected the last result to be p24, got %+v (%v)", p, err)
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Put("people", "ada", &person{Name: "Ada"})
+
+	c := s.Client()
+	for _, test := range []struct {
+		collection, query string
+		limit             int
+		status            int
+	}{
+		{"people", "(unbalanced", 10, 400},
+		{"people", "*", 101, 400},
+		{"missing", "*", 10, 404},
+	} {
+		_, err := c.Search(test.collection, test.query, test.limit, 0)
+		if oe, ok := err.(*gorc.OrchestrateError); !ok || oe.StatusCode != test.status {
+			t.Errorf("Search(%q, %q, %d): expected status %d, got %v", test.collection, test.query, test.limit, test.status, err)
+		}
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+
+	first, err := c.PutIfAbsent("people", "ada", &person{Name: "Ada", Age: 36})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PutIfAbsent("people", "ada", &person{Name: "Ada"}); err == nil {
+		t.Error("expected PutIfAbsent to fail for a present key")
+	}
+
+	second, err := c.PutIfUnmodified(first, &person{Name: "Ada", Age: 37})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PutIfUnmodified(first, &person{Name: "Ada"}); err == nil {
+		t.Error("expected PutIfUnmodified to fail for a stale ref")
+	}
+
+	result, err := c.Get("people", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p person
+	if result.Value(&p); p.Age != 37 || result.Path.Ref != second.Ref {
+		t.Errorf("expected the latest value, got %+v at ref %q", p, result.Path.Ref)
+	}
+
+	result, err = c.GetPath(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value(&p); p.Age != 36 {
+		t.Errorf("expected the value at the first ref, got %+v", p)
+	}
+
+	if err := c.Delete("people", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("people", "ada"); err == nil {
+		t.Error("expected a deleted item to be missing")
+	}
+}
+
+func TestList(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		s.Put("letters", key, map[string]string{"letter": key})
+	}
+	c := s.Client()
+
+	keys := func(results *gorc.KVResults) string {
+		var keys string
+		for _, r := range results.Results {
+			keys += r.Path.Key
+		}
+		return keys
+	}
+
+	results, err := c.List("letters", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys(results) != "ab" || !results.HasNext() {
+		t.Errorf("unexpected first page %q", keys(results))
+	}
+	if results, err = c.ListGetNext(results); err != nil || keys(results) != "cd" {
+		t.Errorf("unexpected second page %q (%v)", keys(results), err)
+	}
+
+	if results, err = c.ListStart("letters", "b", 10); err != nil || keys(results) != "bcde" {
+		t.Errorf("unexpected ListStart page %q (%v)", keys(results), err)
+	}
+	if results, err = c.ListAfter("letters", "b", 10); err != nil || keys(results) != "cde" {
+		t.Errorf("unexpected ListAfter page %q (%v)", keys(results), err)
+	}
+	if results, err = c.ListRange("letters", "b", "d", 10); err != nil || keys(results) != "bcd" {
+		t.Errorf("unexpected ListRange page %q (%v)", keys(results), err)
+	}
+
+	if _, err := c.List("letters", 0); err == nil || err.(*gorc.OrchestrateError).StatusCode != 400 {
+		t.Errorf("expected a limit of 0 to be rejected, got %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Put("people", "ada", &person{Name: "Ada"})
+	c := s.Client()
+
+	for i := int64(1); i <= 3; i++ {
+		if err := c.PutEventWithTime("people", "ada", "visit", i*1000, map[string]int64{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := c.GetEvents("people", "ada", "visit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Count != 3 || results.Results[0].Timestamp != 3000 {
+		t.Errorf("expected 3 events newest first, got %+v", results)
+	}
+
+	if results, err = c.GetEventsInRange("people", "ada", "visit", 1000, 3000); err != nil || results.Count != 2 {
+		t.Errorf("expected 2 events in range, got %+v (%v)", results, err)
+	}
+}
+
+func TestRelations(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Put("people", "ada", &person{Name: "Ada"})
+	s.Put("people", "charles", &person{Name: "Charles"})
+	s.Put("machines", "engine", map[string]string{"name": "Analytical Engine"})
+	c := s.Client()
+
+	if err := c.PutRelation("people", "ada", "knows", "people", "charles"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutRelation("people", "charles", "built", "machines", "engine"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.GetRelations("people", "ada", []string{"knows", "built"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Count != 1 || results.Results[0].Path.Key != "engine" {
+		t.Errorf("expected to reach the engine, got %+v", results)
+	}
+
+	if err := c.DeleteRelation("people", "ada", "knows", "people", "charles"); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = c.GetRelations("people", "ada", []string{"knows"}); err != nil || results.Count != 0 {
+		t.Errorf("expected no relations after delete, got %+v (%v)", results, err)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AuthToken = "secret"
+
+	if err := s.Client().Ping(); err != nil {
+		t.Errorf("expected the matching key to be accepted: %s", err)
+	}
+	if err := gorc.NewClientWithOptions("wrong", &gorc.ClientOptions{BaseURL: s.APIURL()}).Ping(); err == nil {
+		t.Error("expected a wrong key to be rejected")
+	}
+}
+
+func TestIntercept(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Put("people", "ada", &person{Name: "Ada"})
+	c := s.Client()
+
+	s.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("offset") == "1" {
+			w.WriteHeader(503)
+			return true
+		}
+		return false
+	}
+
+	if _, err := c.Search("people", "*", 1, 0); err != nil {
+		t.Errorf("expected requests passed on to be handled: %s", err)
+	}
+	if _, err := c.Search("people", "*", 1, 1); err == nil {
+		t.Error("expected the intercepted request to fail")
+	}
+	if n := s.Requests(); n != 2 {
+		t.Errorf("expected intercepted requests to be counted, got %d", n)
+	}
+}
@@ -3,6 +3,7 @@
 package gorc
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 )
@@ -21,10 +22,15 @@ type GraphResult struct {
 
 // Get all related key/value objects by collection-key and a list of relations.
 func (c *Client) GetRelations(collection, key string, hops []string) (*GraphResults, error) {
+	return c.GetRelationsContext(context.Background(), collection, key, hops)
+}
+
+// Like GetRelations, but the call is abandoned when ctx is done.
+func (c *Client) GetRelationsContext(ctx context.Context, collection, key string, hops []string) (*GraphResults, error) {
 	relationsPath := strings.Join(hops, "/")
 
 	trailingUri := collection + "/" + key + "/relations/" + relationsPath
-	resp, err := c.doRequest("GET", trailingUri, nil, nil)
+	resp, err := c.doRequest(ctx, "GET", trailingUri, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,8 +52,13 @@ func (c *Client) GetRelations(collection, key string, hops []string) (*GraphResu
 
 // Create a relationship of a specified type between two collection-keys.
 func (c *Client) PutRelation(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) error {
+	return c.PutRelationContext(context.Background(), sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+}
+
+// Like PutRelation, but the call is abandoned when ctx is done.
+func (c *Client) PutRelationContext(ctx context.Context, sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) error {
 	trailingUri := sourceCollection + "/" + sourceKey + "/relation/" + kind + "/" + sinkCollection + "/" + sinkKey
-	resp, err := c.doRequest("PUT", trailingUri, nil, nil)
+	resp, err := c.doRequest(ctx, "PUT", trailingUri, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -63,8 +74,13 @@ func (c *Client) PutRelation(sourceCollection, sourceKey, kind, sinkCollection,
 
 // Create a relationship of a specified type between two collection-keys.
 func (c *Client) DeleteRelation(sourceCollection string, sourceKey string, kind string, sinkCollection string, sinkKey string) error {
+	return c.DeleteRelationContext(context.Background(), sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+}
+
+// Like DeleteRelation, but the call is abandoned when ctx is done.
+func (c *Client) DeleteRelationContext(ctx context.Context, sourceCollection string, sourceKey string, kind string, sinkCollection string, sinkKey string) error {
 	trailingUri := sourceCollection + "/" + sourceKey + "/relation/" + kind + "/" + sinkCollection + "/" + sinkKey + "?purge=true"
-	resp, err := c.doRequest("DELETE", trailingUri, nil, nil)
+	resp, err := c.doRequest(ctx, "DELETE", trailingUri, nil, nil)
 
 	if err != nil {
 		return err
@@ -4,6 +4,7 @@ package gorc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,12 +28,22 @@ type KVResult struct {
 
 // Get a collection-key pair's value.
 func (c *Client) Get(collection, key string) (*KVResult, error) {
-	return c.GetPath(&Path{Collection: collection, Key: key})
+	return c.GetContext(context.Background(), collection, key)
+}
+
+// Like Get, but the call is abandoned when ctx is done.
+func (c *Client) GetContext(ctx context.Context, collection, key string) (*KVResult, error) {
+	return c.GetPathContext(ctx, &Path{Collection: collection, Key: key})
 }
 
 // Get the value at a path.
 func (c *Client) GetPath(path *Path) (*KVResult, error) {
-	resp, err := c.doRequest("GET", path.trailingGetURI(), nil, nil)
+	return c.GetPathContext(context.Background(), path)
+}
+
+// Like GetPath, but the call is abandoned when ctx is done.
+func (c *Client) GetPathContext(ctx context.Context, path *Path) (*KVResult, error) {
+	resp, err := c.doRequest(ctx, "GET", path.trailingGetURI(), nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -62,57 +73,87 @@ func (c *Client) GetPath(path *Path) (*KVResult, error) {
 
 // Store a value to a collection-key pair.
 func (c *Client) Put(collection string, key string, value interface{}) (*Path, error) {
+	return c.PutContext(context.Background(), collection, key, value)
+}
+
+// Like Put, but the call is abandoned when ctx is done.
+func (c *Client) PutContext(ctx context.Context, collection string, key string, value interface{}) (*Path, error) {
 	reader, writer := io.Pipe()
 	encoder := json.NewEncoder(writer)
 
 	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutRaw(collection, key, reader)
+	return c.PutRawContext(ctx, collection, key, reader)
 }
 
 // Store a value to a collection-key pair.
 func (c *Client) PutRaw(collection string, key string, value io.Reader) (*Path, error) {
-	return c.doPut(&Path{Collection: collection, Key: key}, nil, value)
+	return c.PutRawContext(context.Background(), collection, key, value)
+}
+
+// Like PutRaw, but the call is abandoned when ctx is done.
+func (c *Client) PutRawContext(ctx context.Context, collection string, key string, value io.Reader) (*Path, error) {
+	return c.doPut(ctx, &Path{Collection: collection, Key: key}, nil, value)
 }
 
 // Store a value to a collection-key pair if the path's ref value is the latest.
 func (c *Client) PutIfUnmodified(path *Path, value interface{}) (*Path, error) {
+	return c.PutIfUnmodifiedContext(context.Background(), path, value)
+}
+
+// Like PutIfUnmodified, but the call is abandoned when ctx is done.
+func (c *Client) PutIfUnmodifiedContext(ctx context.Context, path *Path, value interface{}) (*Path, error) {
 	reader, writer := io.Pipe()
 	encoder := json.NewEncoder(writer)
 
 	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutIfUnmodifiedRaw(path, reader)
+	return c.PutIfUnmodifiedRawContext(ctx, path, reader)
 }
 
 // Store a value to a collection-key pair if the path's ref value is the latest.
 func (c *Client) PutIfUnmodifiedRaw(path *Path, value io.Reader) (*Path, error) {
+	return c.PutIfUnmodifiedRawContext(context.Background(), path, value)
+}
+
+// Like PutIfUnmodifiedRaw, but the call is abandoned when ctx is done.
+func (c *Client) PutIfUnmodifiedRawContext(ctx context.Context, path *Path, value io.Reader) (*Path, error) {
 	headers := map[string]string{
 		"If-Match": `"` + path.Ref + `"`,
 	}
 
-	return c.doPut(path, headers, value)
+	return c.doPut(ctx, path, headers, value)
 }
 
 // Store a value to a collection-key pair if it doesn't already hold a value.
 func (c *Client) PutIfAbsent(collection, key string, value interface{}) (*Path, error) {
+	return c.PutIfAbsentContext(context.Background(), collection, key, value)
+}
+
+// Like PutIfAbsent, but the call is abandoned when ctx is done.
+func (c *Client) PutIfAbsentContext(ctx context.Context, collection, key string, value interface{}) (*Path, error) {
 	reader, writer := io.Pipe()
 	encoder := json.NewEncoder(writer)
 
 	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutIfAbsentRaw(collection, key, reader)
+	return c.PutIfAbsentRawContext(ctx, collection, key, reader)
 }
 
 // Store a value to a collection-key pair if it doesn't already hold a value.
 func (c *Client) PutIfAbsentRaw(collection, key string, value io.Reader) (*Path, error) {
+	return c.PutIfAbsentRawContext(context.Background(), collection, key, value)
+}
+
+// Like PutIfAbsentRaw, but the call is abandoned when ctx is done.
+func (c *Client) PutIfAbsentRawContext(ctx context.Context, collection, key string, value io.Reader) (*Path, error) {
 	headers := map[string]string{
 		"If-None-Match": "\"*\"",
 	}
 
-	return c.doPut(&Path{Collection: collection, Key: key}, headers, value)
+	return c.doPut(ctx, &Path{Collection: collection, Key: key}, headers, value)
 }
 
 // Execute a key/value Put.
-func (c *Client) doPut(path *Path, headers map[string]string, value io.Reader) (*Path, error) {
-	resp, err := c.doRequest("PUT", path.trailingPutURI(), headers, value)
+func (c *Client) doPut(ctx context.Context, path *Path, headers map[string]string, value io.Reader) (*Path, error) {
+	resp, err := c.doRequest(ctx, "PUT", path.trailingPutURI(), headers, value)
 	if err != nil {
 		return nil, err
 	}
@@ -140,32 +181,52 @@ func (c *Client) doPut(path *Path, headers map[string]string, value io.Reader) (
 
 // Delete the value held at a collection-key pair.
 func (c *Client) Delete(collection, key string) error {
-	return c.doDelete(collection+"/"+key, nil)
+	return c.DeleteContext(context.Background(), collection, key)
+}
+
+// Like Delete, but the call is abandoned when ctx is done.
+func (c *Client) DeleteContext(ctx context.Context, collection, key string) error {
+	return c.doDelete(ctx, collection+"/"+key, nil)
 }
 
 // Delete the value held at a collection-key par if the path's ref value is the
 // latest.
 func (c *Client) DeleteIfUnmodified(path *Path) error {
+	return c.DeleteIfUnmodifiedContext(context.Background(), path)
+}
+
+// Like DeleteIfUnmodified, but the call is abandoned when ctx is done.
+func (c *Client) DeleteIfUnmodifiedContext(ctx context.Context, path *Path) error {
 	headers := map[string]string{
 		"If-Match": `"` + path.Ref + `"`,
 	}
 
-	return c.doDelete(path.trailingPutURI(), headers)
+	return c.doDelete(ctx, path.trailingPutURI(), headers)
 }
 
 // Delete the current and all previous values from a collection-key pair.
 func (c *Client) Purge(collection, key string) error {
-	return c.doDelete(collection+"/"+key+"?purge=true", nil)
+	return c.PurgeContext(context.Background(), collection, key)
+}
+
+// Like Purge, but the call is abandoned when ctx is done.
+func (c *Client) PurgeContext(ctx context.Context, collection, key string) error {
+	return c.doDelete(ctx, collection+"/"+key+"?purge=true", nil)
 }
 
 // Delete a collection.
 func (c *Client) DeleteCollection(collection string) error {
-	return c.doDelete(collection+"?force=true", nil)
+	return c.DeleteCollectionContext(context.Background(), collection)
+}
+
+// Like DeleteCollection, but the call is abandoned when ctx is done.
+func (c *Client) DeleteCollectionContext(ctx context.Context, collection string) error {
+	return c.doDelete(ctx, collection+"?force=true", nil)
 }
 
 // Execute delete
-func (c *Client) doDelete(trailingUri string, headers map[string]string) error {
-	resp, err := c.doRequest("DELETE", trailingUri, headers, nil)
+func (c *Client) doDelete(ctx context.Context, trailingUri string, headers map[string]string) error {
+	resp, err := c.doRequest(ctx, "DELETE", trailingUri, headers, nil)
 
 	if err != nil {
 		return err
@@ -182,18 +243,28 @@ func (c *Client) doDelete(trailingUri string, headers map[string]string) error {
 
 // List the values in a collection in key order with the specified page size.
 func (c *Client) List(collection string, limit int) (*KVResults, error) {
+	return c.ListContext(context.Background(), collection, limit)
+}
+
+// Like List, but the call is abandoned when ctx is done.
+func (c *Client) ListContext(ctx context.Context, collection string, limit int) (*KVResults, error) {
 	queryVariables := url.Values{
 		"limit": []string{strconv.Itoa(limit)},
 	}
 
 	trailingUri := collection + "?" + queryVariables.Encode()
 
-	return c.doList(trailingUri)
+	return c.doList(ctx, trailingUri)
 }
 
 // List the values in a collection in key order with the specified page size
 // that come after the specified key.
 func (c *Client) ListAfter(collection, after string, limit int) (*KVResults, error) {
+	return c.ListAfterContext(context.Background(), collection, after, limit)
+}
+
+// Like ListAfter, but the call is abandoned when ctx is done.
+func (c *Client) ListAfterContext(ctx context.Context, collection, after string, limit int) (*KVResults, error) {
 	queryVariables := url.Values{
 		"limit":    []string{strconv.Itoa(limit)},
 		"afterKey": []string{after},
@@ -201,12 +272,17 @@ func (c *Client) ListAfter(collection, after string, limit int) (*KVResults, err
 
 	trailingUri := collection + "?" + queryVariables.Encode()
 
-	return c.doList(trailingUri)
+	return c.doList(ctx, trailingUri)
 }
 
 // List the values in a collection in key order with the specified page size
 // starting with the specified key.
 func (c *Client) ListStart(collection, start string, limit int) (*KVResults, error) {
+	return c.ListStartContext(context.Background(), collection, start, limit)
+}
+
+// Like ListStart, but the call is abandoned when ctx is done.
+func (c *Client) ListStartContext(ctx context.Context, collection, start string, limit int) (*KVResults, error) {
 	queryVariables := url.Values{
 		"limit":    []string{strconv.Itoa(limit)},
 		"startKey": []string{start},
@@ -214,12 +290,17 @@ func (c *Client) ListStart(collection, start string, limit int) (*KVResults, err
 
 	trailingUri := collection + "?" + queryVariables.Encode()
 
-	return c.doList(trailingUri)
+	return c.doList(ctx, trailingUri)
 }
 
 // List the values in a collection within a given range of keys, starting with the
 // specified key and stopping at the end key
 func (c *Client) ListRange(collection, start, end string, limit int) (*KVResults, error) {
+	return c.ListRangeContext(context.Background(), collection, start, end, limit)
+}
+
+// Like ListRange, but the call is abandoned when ctx is done.
+func (c *Client) ListRangeContext(ctx context.Context, collection, start, end string, limit int) (*KVResults, error) {
 	queryVariables := url.Values{
 		"limit":    []string{strconv.Itoa(limit)},
 		"startKey": []string{start},
@@ -228,17 +309,22 @@ func (c *Client) ListRange(collection, start, end string, limit int) (*KVResults
 
 	trailingUri := collection + "?" + queryVariables.Encode()
 
-	return c.doList(trailingUri)
+	return c.doList(ctx, trailingUri)
 }
 
 // Get the page of key/value list results that follow that provided set.
 func (c *Client) ListGetNext(results *KVResults) (*KVResults, error) {
-	return c.doList(results.Next[4:])
+	return c.ListGetNextContext(context.Background(), results)
+}
+
+// Like ListGetNext, but the call is abandoned when ctx is done.
+func (c *Client) ListGetNextContext(ctx context.Context, results *KVResults) (*KVResults, error) {
+	return c.doList(ctx, results.Next[4:])
 }
 
 // Execute a key/value list operation.
-func (c *Client) doList(trailingUri string) (*KVResults, error) {
-	resp, err := c.doRequest("GET", trailingUri, nil, nil)
+func (c *Client) doList(ctx context.Context, trailingUri string) (*KVResults, error) {
+	resp, err := c.doRequest(ctx, "GET", trailingUri, nil, nil)
 	if err != nil {
 		return nil, err
 	}
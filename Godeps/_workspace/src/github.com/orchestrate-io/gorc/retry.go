@@ -0,0 +1,199 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorc
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Controls how a Client retries calls that fail in ways that are likely to be
+// temporary. Only idempotent calls are retried: GET and HEAD, and DELETE
+// unless it is conditional. Calls are retried after connection failures and
+// after 429, 502, 503 and 504 responses. Fields left at their zero value
+// select the defaults.
+type RetryPolicy struct {
+	// The most attempts made for a call, including the first. The default
+	// is 3; 1 disables retries.
+	MaxAttempts int
+
+	// The delay before the first retry, which doubles with each further
+	// retry up to MaxDelay. Each delay is randomly shortened by up to half to
+	// spread retries out. The defaults are 50ms and 1s.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// The retry budget, shared by every call made with the Client. Each
+	// call adds BudgetRatio to the budget, up to BudgetBurst, and each retry
+	// spends one from it; retries are not made when it is spent. This limits
+	// retries to about BudgetRatio of calls during an outage, rather than
+	// multiplying the load on a struggling service. The defaults are 0.1
+	// and 10.
+	BudgetRatio float64
+	BudgetBurst float64
+}
+
+// The retry policy in effect for a Client, with defaults applied, and its
+// budget.
+type retrier struct {
+	policy RetryPolicy
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetrier(policy *RetryPolicy) *retrier {
+	r := &retrier{policy: *policy}
+	p := &r.policy
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = 50 * time.Millisecond
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = time.Second
+	}
+	if p.BudgetRatio == 0 {
+		p.BudgetRatio = 0.1
+	}
+	if p.BudgetBurst == 0 {
+		p.BudgetBurst = 10
+	}
+	r.tokens = p.BudgetBurst
+	return r
+}
+
+// Reports whether a request may be retried at all.
+func (r *retrier) retryable(req *http.Request) bool {
+	if r.policy.MaxAttempts < 2 {
+		return false
+	}
+	switch req.Method {
+	case "GET", "HEAD":
+		return true
+	case "DELETE":
+		return req.Header.Get("If-Match") == ""
+	}
+	return false
+}
+
+// Adds a call's share to the retry budget.
+func (r *retrier) deposit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens += r.policy.BudgetRatio
+	if r.tokens > r.policy.BudgetBurst {
+		r.tokens = r.policy.BudgetBurst
+	}
+}
+
+// Spends one retry from the budget, reporting whether there was one to spend.
+func (r *retrier) withdraw() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Decides whether to retry after the given attempt, and how long to wait
+// first. A Retry-After header longer than MaxDelay is honored by not retrying
+// at all.
+func (r *retrier) backoff(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= r.policy.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil {
+		if !isConnectError(err) {
+			return 0, false
+		}
+	} else {
+		switch resp.StatusCode {
+		case 429, 502, 503, 504:
+		default:
+			return 0, false
+		}
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return after, after <= r.policy.MaxDelay
+		}
+	}
+
+	delay := r.policy.BaseDelay << uint(attempt-1)
+	if delay > r.policy.MaxDelay || delay <= 0 {
+		delay = r.policy.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// Reports whether an error happened while connecting, so that the request
+// never reached Orchestrate.
+func isConnectError(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+// Parses a Retry-After header, which holds either a number of seconds or an
+// HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Sends a request, retrying it as the policy allows. Retries stop once the
+// request's context is done.
+func (r *retrier) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	r.deposit()
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		delay, retry := r.backoff(attempt, resp, err)
+		if !retry || !r.withdraw() {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: req.Context().Err()}
+		}
+	}
+}
+
+// Returns the operation named in a *url.Error for a method, as net/http does.
+func urlErrorOp(method string) string {
+	return method[:1] + strings.ToLower(method[1:])
+}
@@ -0,0 +1,164 @@
+// Copyright 2014, Orchestrate.IO, Inc.
+
+package gorc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A server that answers each request with the next of a list of statuses,
+// repeating the last, and counts the requests.
+type flakyServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	requests   int
+}
+
+func newFlakyServer(statuses ...int) *flakyServer {
+	s := &flakyServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		status := s.statuses[len(s.statuses)-1]
+		if s.requests < len(s.statuses) {
+			status = s.statuses[s.requests]
+		}
+		s.requests++
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "try again"}`))
+	}))
+	return s
+}
+
+func (s *flakyServer) client(policy *RetryPolicy) *Client {
+	return NewClientWithOptions("key", &ClientOptions{BaseURL: s.URL + "/v0/", Retry: policy})
+}
+
+var fastRetries = &RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestRetryIdempotentCalls(t *testing.T) {
+	for _, test := range []struct {
+		statuses []int
+		call     func(c *Client) error
+		requests int
+		ok       bool
+	}{
+		{[]int{502, 503, 200}, func(c *Client) error { return c.Ping() }, 3, true},
+		{[]int{504, 429, 504}, func(c *Client) error { return c.Ping() }, 3, false},
+		{[]int{500, 200}, func(c *Client) error { return c.Ping() }, 1, false},
+		{[]int{503, 204}, func(c *Client) error { return c.Delete("people", "ada") }, 2, true},
+		{[]int{503, 204}, func(c *Client) error { return c.Purge("people", "ada") }, 2, true},
+		{[]int{503, 201}, func(c *Client) error { _, err := c.Put("people", "ada", map[string]string{}); return err }, 1, false},
+	} {
+		s := newFlakyServer(test.statuses...)
+		err := test.call(s.client(fastRetries))
+		if (err == nil) != test.ok || s.requests != test.requests {
+			t.Errorf("%v: expected %d requests and success %t, got %d requests and %v", test.statuses, test.requests, test.ok, s.requests, err)
+		}
+		s.Close()
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	s := newFlakyServer(503)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.client(&RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}).PingContext(ctx)
+
+	if e, ok := err.(*url.Error); !ok || e.Err != context.DeadlineExceeded || s.requests != 1 {
+		t.Errorf("expected the deadline to stop the retries, got %d requests and %v", s.requests, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the backoff to be cut short, took %s", elapsed)
+	}
+}
+
+func TestRetryConditionalDelete(t *testing.T) {
+	s := newFlakyServer(503, 204)
+	defer s.Close()
+
+	if err := s.client(fastRetries).DeleteIfUnmodified(&Path{Collection: "people", Key: "ada", Ref: "abc"}); err == nil || s.requests != 1 {
+		t.Errorf("expected a conditional delete not to be retried, got %d requests and %v", s.requests, err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := newFlakyServer(503, 200)
+	defer s.Close()
+
+	s.retryAfter = "120"
+	if err := s.client(fastRetries).Ping(); err == nil || s.requests != 1 {
+		t.Errorf("expected a long Retry-After not to be retried, got %d requests and %v", s.requests, err)
+	}
+
+	s.requests, s.retryAfter = 0, "0"
+	if err := s.client(fastRetries).Ping(); err != nil || s.requests != 2 {
+		t.Errorf("expected a short Retry-After to be retried, got %d requests and %v", s.requests, err)
+	}
+
+	now := time.Date(2014, 6, 1, 10, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"3":                             3 * time.Second,
+		"Sun, 01 Jun 2014 10:00:05 GMT": 5 * time.Second,
+		"Sun, 01 Jun 2014 09:00:00 GMT": 0,
+	} {
+		if d, ok := retryAfter(value, now); !ok || d != expected {
+			t.Errorf("retryAfter(%q): expected %s, got %s (%t)", value, expected, d, ok)
+		}
+	}
+	if _, ok := retryAfter("soon", now); ok {
+		t.Error("expected an invalid Retry-After to be ignored")
+	}
+}
+
+func TestRetryConnectErrors(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+
+	transport := &countingTransport{}
+	c := NewClientWithOptions("key", &ClientOptions{BaseURL: s.URL + "/v0/", Transport: transport, Retry: fastRetries})
+	if err := c.Ping(); err == nil || transport.count != 3 {
+		t.Errorf("expected 3 attempts to connect, got %d and %v", transport.count, err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	s := newFlakyServer(503)
+	defer s.Close()
+
+	c := s.client(&RetryPolicy{BaseDelay: time.Millisecond, BudgetRatio: 0.01, BudgetBurst: 1})
+	c.Ping()
+	c.Ping()
+	if s.requests != 3 {
+		t.Errorf("expected the budget to allow a single retry, got %d requests", s.requests)
+	}
+}
+
+func TestBackoffGrows(t *testing.T) {
+	r := newRetrier(&RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: 400 * time.Millisecond})
+	resp := &http.Response{StatusCode: 503, Header: http.Header{}}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 400} {
+		max *= time.Millisecond
+		delay, ok := r.backoff(attempt+1, resp, nil)
+		if !ok || delay < max/2 || delay > max {
+			t.Errorf("attempt %d: expected a delay between %s and %s, got %s", attempt+1, max/2, max, delay)
+		}
+	}
+}
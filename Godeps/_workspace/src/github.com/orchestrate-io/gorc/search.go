@@ -3,6 +3,7 @@
 package gorc
 
 import (
+	"context"
 	"encoding/json"
 	"net/url"
 	"strconv"
@@ -28,6 +29,11 @@ type SearchResult struct {
 // (http://lucene.apache.org/core/4_5_1/queryparser/org/apache/lucene/queryparser/classic/package-summary.html#Overview)
 // and with a specified size limit and offset.
 func (c *Client) Search(collection, query string, limit, offset int) (*SearchResults, error) {
+	return c.SearchContext(context.Background(), collection, query, limit, offset)
+}
+
+// Like Search, but the call is abandoned when ctx is done.
+func (c *Client) SearchContext(ctx context.Context, collection, query string, limit, offset int) (*SearchResults, error) {
 	queryVariables := url.Values{
 		"query":  []string{query},
 		"limit":  []string{strconv.Itoa(limit)},
@@ -36,22 +42,32 @@ func (c *Client) Search(collection, query string, limit, offset int) (*SearchRes
 
 	trailingUri := collection + "?" + queryVariables.Encode()
 
-	return c.doSearch(trailingUri)
+	return c.doSearch(ctx, trailingUri)
 }
 
 // Get the page of search results that follow that provided set.
 func (c *Client) SearchGetNext(results *SearchResults) (*SearchResults, error) {
-	return c.doSearch(results.Next[4:])
+	return c.SearchGetNextContext(context.Background(), results)
+}
+
+// Like SearchGetNext, but the call is abandoned when ctx is done.
+func (c *Client) SearchGetNextContext(ctx context.Context, results *SearchResults) (*SearchResults, error) {
+	return c.doSearch(ctx, results.Next[4:])
 }
 
 // Get the page of search results that precede that provided set.
 func (c *Client) SearchGetPrev(results *SearchResults) (*SearchResults, error) {
-	return c.doSearch(results.Prev[4:])
+	return c.SearchGetPrevContext(context.Background(), results)
+}
+
+// Like SearchGetPrev, but the call is abandoned when ctx is done.
+func (c *Client) SearchGetPrevContext(ctx context.Context, results *SearchResults) (*SearchResults, error) {
+	return c.doSearch(ctx, results.Prev[4:])
 }
 
 // Execute a search request.
-func (c *Client) doSearch(trailingUri string) (*SearchResults, error) {
-	resp, err := c.doRequest("GET", trailingUri, nil, nil)
+func (c *Client) doSearch(ctx context.Context, trailingUri string) (*SearchResults, error) {
+	resp, err := c.doRequest(ctx, "GET", trailingUri, nil, nil)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hoisie/web"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How log lines are written.
+type logConfig struct {
+	// The line format: "logfmt" (the default) or "json".
+	Format string `json:"format"`
+
+	// The fraction of successful requests that are logged, between 0 and 1.
+	// Requests that fail with a 4xx or 5xx status are always logged. If
+	// nil then every request is logged.
+	SampleRate *float64 `json:"sample_rate"`
+
+	// Whether access lines carry the raw query rather than a hash of it.
+	// Queries can hold personal data, so they are hashed by default.
+	RawQueries bool `json:"raw_queries"`
+}
+
+// Fills in defaults for unset fields and validates the configuration.
+func (l *logConfig) init() error {
+	switch l.Format {
+	case "":
+		l.Format = "logfmt"
+	case "logfmt", "json":
+	default:
+		return fmt.Errorf("format must be logfmt or json")
+	}
+
+	if l.SampleRate == nil {
+		rate := 1.0
+		l.SampleRate = &rate
+	} else if *l.SampleRate < 0 || *l.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// The logger every line is written through. It is replaced when the proxy is
+// configured.
+var logger = newLogger(os.Stdout, &logConfig{})
+
+// Writes structured log lines, one per event.
+type structuredLogger struct {
+	config *logConfig
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newLogger(out io.Writer, config *logConfig) *structuredLogger {
+	config.init()
+	return &structuredLogger{config: config, out: out}
+}
+
+// A key and value in a log line.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// Writes a line holding the given fields, in order. Fields with empty values
+// are left out.
+func (l *structuredLogger) write(fields ...logField) {
+	buf := new(bytes.Buffer)
+	if l.config.Format == "json" {
+		buf.WriteByte('{')
+	}
+
+	first := true
+	for _, field := range fields {
+		if field.value == nil || field.value == "" {
+			continue
+		}
+		if !first {
+			if l.config.Format == "json" {
+				buf.WriteByte(',')
+			} else {
+				buf.WriteByte(' ')
+			}
+		}
+		first = false
+
+		if l.config.Format == "json" {
+			key, _ := json.Marshal(field.key)
+			value, _ := json.Marshal(field.value)
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		} else {
+			buf.WriteString(field.key)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(field.value))
+		}
+	}
+
+	if l.config.Format == "json" {
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	l.out.Write(buf.Bytes())
+	l.mu.Unlock()
+}
+
+// Formats a value for logfmt, quoting it if it holds spaces, quotes, equals
+// signs or control characters. Quoting escapes control characters, so
+// client supplied values can't forge lines or terminal escapes.
+func logfmtValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.IndexFunc(s, needsQuoting) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == 0x7f || r == '=' || r == '"' || r == '\\'
+}
+
+// Logs a message about a request, or about no request when ctx is nil.
+func logMessage(ctx *web.Context, level, format string, args ...interface{}) {
+	fields := []logField{{"level", level}, {"msg", fmt.Sprintf(format, args...)}}
+	if ctx != nil {
+		fields = append(fields, logField{"request_id", requestID(ctx)})
+	}
+	logger.write(fields...)
+}
+
+func logInfo(ctx *web.Context, format string, args ...interface{}) {
+	logMessage(ctx, "info", format, args...)
+}
+
+func logError(ctx *web.Context, format string, args ...interface{}) {
+	logMessage(ctx, "error", format, args...)
+}
+
+// What the access log records about a request. Handlers fill it in as they
+// go, through accessEntryOf.
+type accessEntry struct {
+	start      time.Time
+	collection string
+	query      string
+	limit      int
+	offset     int
+	count      int
+	totalCount int
+	upstream   time.Duration
+	counted    bool
+}
+
+// Records the search a request resolved to.
+func (e *accessEntry) search(req *searchRequest) {
+	e.collection = req.collection
+	e.query = req.query
+	e.limit = req.limit
+	e.offset = req.offset
+}
+
+// Records the size of a page of results.
+func (e *accessEntry) results(count, totalCount int) {
+	e.count, e.totalCount, e.counted = count, totalCount, true
+}
+
+// Returns the access log entry of a request. Requests that aren't tracked
+// get a throwaway entry, so callers needn't check.
+func accessEntryOf(ctx *web.Context) *accessEntry {
+	if w, ok := ctx.ResponseWriter.(*trackedResponse); ok {
+		return w.entry
+	}
+	return new(accessEntry)
+}
+
+// Writes the access log line of a finished request, subject to sampling.
+func logAccess(ctx *web.Context, route string, status int, e *accessEntry) {
+	if status < 400 && rand.Float64() >= *logger.config.SampleRate {
+		return
+	}
+
+	fields := []logField{
+		{"level", "info"},
+		{"event", "request"},
+		{"request_id", requestID(ctx)},
+		{"method", ctx.Request.Method},
+		{"path", ctx.Request.URL.Path},
+		{"route", route},
+		{"client_ip", clientIP(ctx.Request, *conf.ProxyHops)},
+		{"collection", e.collection},
+	}
+	if e.collection != "" {
+		if logger.config.RawQueries {
+			fields = append(fields, logField{"query", e.query})
+		} else {
+			fields = append(fields, logField{"query_hash", queryHash(e.query)})
+		}
+		fields = append(fields, logField{"limit", e.limit}, logField{"offset", e.offset})
+	}
+	if e.counted {
+		fields = append(fields, logField{"count", e.count}, logField{"total_count", e.totalCount})
+	}
+	fields = append(fields,
+		logField{"upstream_ms", milliseconds(e.upstream)},
+		logField{"duration_ms", milliseconds(time.Since(e.start))},
+		logField{"status", status},
+	)
+
+	logger.write(fields...)
+}
+
+// Returns a short hash identifying a query without revealing it.
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hoisie/web"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fields := []logField{{"level", "info"}, {"msg", `say "hi"`}, {"empty", ""}, {"n", 3}}
+
+	newLogger(buf, &logConfig{}).write(fields...)
+	if expected := `level=info msg="say \"hi\"" n=3` + "\n"; buf.String() != expected {
+		t.Errorf("expected logfmt %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	newLogger(buf, &logConfig{Format: "json"}).write(fields...)
+	if expected := `{"level":"info","msg":"say \"hi\"","n":3}` + "\n"; buf.String() != expected {
+		t.Errorf("expected JSON %q, got %q", expected, buf.String())
+	}
+}
+
+func TestLogfmtValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"plain":          "plain",
+		"a b":            `"a b"`,
+		"a=b":            `"a=b"`,
+		"\x1b[31mred":    `"\x1b[31mred"`,
+		"del\x7f":        `"del\x7f"`,
+		"x\nlevel=error": `"x\nlevel=error"`,
+		"":               `""`,
+	} {
+		if got := logfmtValue(value); got != expected {
+			t.Errorf("logfmtValue(%q) = %s, expected %s", value, got, expected)
+		}
+	}
+}
+
+func TestCrashLogged(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	buf := new(bytes.Buffer)
+	logger = newLogger(buf, &logConfig{})
+
+	// The proxy's catch-all route would shadow one added to its server.
+	p.server = web.NewServer()
+	p.server.Logger = log.New(ioutil.Discard, "", 0)
+	p.server.Get("/crash", instrumentRoute("crash", func(ctx *web.Context) { panic("boom") }))
+	if w := p.get("/crash", nil); w.Code != 500 {
+		t.Errorf("expected a crash to be a 500, got %d", w.Code)
+	}
+	if !strings.Contains(buf.String(), `msg="handler crashed: boom`) {
+		t.Errorf("expected the crash to be logged, got %q", buf.String())
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	for _, data := range []string{
+		`{"log": {"format": "xml"}}`,
+		`{"log": {"sample_rate": 1.5}}`,
+	} {
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Errorf("expected %s to be rejected", data)
+		}
+	}
+}
+
+// Returns the JSON access log lines written to buf.
+func accessLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("expected a JSON line, got %q", line)
+		}
+		if fields["event"] == "request" {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	buf := new(bytes.Buffer)
+	logger = newLogger(buf, &logConfig{Format: "json"})
+	p.get("/people?query=name:Ada&limit=1&key=", map[string][]string{"X-Request-Id": {"abc123"}})
+
+	lines := accessLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected one access line, got %q", buf.String())
+	}
+	line := lines[0]
+	for key, value := range map[string]interface{}{
+		"request_id":  "abc123",
+		"route":       "search",
+		"collection":  "people",
+		"query_hash":  queryHash("name:Ada"),
+		"limit":       1.0,
+		"count":       1.0,
+		"total_count": 1.0,
+		"status":      200.0,
+	} {
+		if line[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["upstream_ms"]; !ok {
+		t.Error("expected the upstream latency to be logged")
+	}
+	if strings.Contains(buf.String(), "name:Ada") {
+		t.Error("expected the query to be hashed")
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	rate := 0.0
+	buf := new(bytes.Buffer)
+	logger = newLogger(buf, &logConfig{Format: "json", SampleRate: &rate, RawQueries: true})
+	p.get("/people", nil)
+	p.get("/nobody?query=secret", nil)
+
+	lines := accessLines(t, buf)
+	if len(lines) != 1 || lines[0]["status"] != 404.0 {
+		t.Fatalf("expected only the failed request to be logged, got %q", buf.String())
+	}
+	if _, ok := lines[0]["query"]; ok {
+		t.Error("expected no query for a request that was not admitted")
+	}
+}
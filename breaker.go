@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The number of buckets the breaker's window is divided into.
+const breakerBuckets = 10
+
+// The shortest window the breaker accepts. Shorter windows would make buckets
+// too small to count anything in, or, below breakerBuckets nanoseconds, empty.
+const minBreakerWindow = time.Second
+
+// Returned in place of calling Orchestrate while the circuit is open.
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// When the circuit breaker around Orchestrate trips, and how it recovers.
+type breakerConfig struct {
+	// Disables the breaker, so that every call is made.
+	Disabled bool `json:"disabled"`
+
+	// The period over which error and slow call rates are measured.
+	Window duration `json:"window"`
+
+	// The fewest calls in the window before the breaker may trip.
+	MinRequests int `json:"min_requests"`
+
+	// The fraction of calls that must fail, with a network error or a 5xx,
+	// to trip the breaker.
+	ErrorRate float64 `json:"error_rate"`
+
+	// Calls slower than SlowCall are slow; the fraction of calls that must
+	// be slow to trip the breaker.
+	SlowCall duration `json:"slow_call"`
+	SlowRate float64  `json:"slow_rate"`
+
+	// How long the breaker stays open before letting trial calls through.
+	OpenFor duration `json:"open_for"`
+
+	// The number of trial calls that must succeed to close the breaker.
+	Probes int `json:"probes"`
+}
+
+// Fills in defaults for unset fields and validates the configuration.
+func (b *breakerConfig) init() error {
+	if b.Window.Duration == 0 {
+		b.Window.Duration = 10 * time.Second
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = 20
+	}
+	if b.ErrorRate == 0 {
+		b.ErrorRate = 0.5
+	}
+	if b.SlowCall.Duration == 0 {
+		b.SlowCall.Duration = 2 * time.Second
+	}
+	if b.SlowRate == 0 {
+		b.SlowRate = 0.5
+	}
+	if b.OpenFor.Duration == 0 {
+		b.OpenFor.Duration = 30 * time.Second
+	}
+	if b.Probes == 0 {
+		b.Probes = 1
+	}
+
+	switch {
+	case b.Window.Duration < 0 || b.SlowCall.Duration < 0 || b.OpenFor.Duration < 0:
+		return fmt.Errorf("durations must be positive")
+	case b.Window.Duration < minBreakerWindow:
+		return fmt.Errorf("window must be at least %s", minBreakerWindow)
+	case b.MinRequests < 1 || b.Probes < 1:
+		return fmt.Errorf("min_requests and probes must be positive")
+	case b.ErrorRate < 0 || b.ErrorRate > 1 || b.SlowRate < 0 || b.SlowRate > 1:
+		return fmt.Errorf("error_rate and slow_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// The states of a circuit breaker.
+type breakerState int
+
+const (
+	// Calls are made, and their outcomes watched.
+	breakerClosed breakerState = iota
+
+	// Calls fail at once, without reaching Orchestrate.
+	breakerOpen
+
+	// A few trial calls are let through to see whether Orchestrate has
+	// recovered.
+	breakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half_open"}
+
+func (s breakerState) String() string {
+	return breakerStateNames[s]
+}
+
+// A circuit breaker. It trips open when too many calls in its window fail or
+// are slow, and after a while lets trial calls through, closing again once
+// they succeed.
+type circuitBreaker struct {
+	config *breakerConfig
+
+	mu      sync.Mutex
+	state   breakerState
+	changed time.Time
+	buckets [breakerBuckets]breakerBucket
+
+	// Trial calls in flight and succeeded while half open.
+	probing, probed int
+}
+
+// The outcomes of calls in one slice of the window.
+type breakerBucket struct {
+	epoch                 int64
+	calls, failures, slow int
+}
+
+func newCircuitBreaker(config *breakerConfig) *circuitBreaker {
+	b := &circuitBreaker{config: config, changed: time.Now()}
+	b.record(breakerClosed)
+	return b
+}
+
+// Asks to make a call, reporting whether it may go ahead. Every call that
+// goes ahead must be followed by done.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && now.Sub(b.changed) >= b.config.OpenFor.Duration {
+		b.transition(breakerHalfOpen, now)
+	}
+
+	switch b.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if b.probing+b.probed >= b.config.Probes {
+			return false
+		}
+		b.probing++
+	}
+	return true
+}
+
+// Records the outcome of a call allowed by allow.
+func (b *circuitBreaker) done(now time.Time, latency time.Duration, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slow := latency >= b.config.SlowCall.Duration
+	switch b.state {
+	case breakerHalfOpen:
+		b.probing--
+		if failed || slow {
+			b.transition(breakerOpen, now)
+		} else if b.probed++; b.probed >= b.config.Probes {
+			b.transition(breakerClosed, now)
+		}
+	case breakerClosed:
+		bucket := b.bucket(now)
+		bucket.calls++
+		if failed {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+
+		calls, failures, slowCalls := b.totals(now)
+		if calls >= b.config.MinRequests &&
+			(float64(failures) >= b.config.ErrorRate*float64(calls) || float64(slowCalls) >= b.config.SlowRate*float64(calls)) {
+			b.transition(breakerOpen, now)
+		}
+	}
+}
+
+// Releases a call allowed by allow whose outcome says nothing about
+// Orchestrate, such as one the client gave up on.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing--
+	}
+}
+
+// Returns the breaker's state and when it last changed.
+func (b *circuitBreaker) status(now time.Time) (breakerState, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && now.Sub(b.changed) >= b.config.OpenFor.Duration {
+		b.transition(breakerHalfOpen, now)
+	}
+	return b.state, b.changed
+}
+
+// Returns the bucket for now, clearing it if it belongs to an earlier pass
+// through the window.
+func (b *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	epoch := now.UnixNano() / int64(b.config.Window.Duration/breakerBuckets)
+	bucket := &b.buckets[epoch%breakerBuckets]
+	if bucket.epoch != epoch {
+		*bucket = breakerBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+// Sums the outcomes of the calls in the window.
+func (b *circuitBreaker) totals(now time.Time) (calls, failures, slow int) {
+	oldest := now.UnixNano()/int64(b.config.Window.Duration/breakerBuckets) - breakerBuckets
+	for _, bucket := range b.buckets {
+		if bucket.epoch > oldest {
+			calls += bucket.calls
+			failures += bucket.failures
+			slow += bucket.slow
+		}
+	}
+	return
+}
+
+func (b *circuitBreaker) transition(state breakerState, now time.Time) {
+	if state != b.state {
+		logInfo(nil, "Circuit breaker %s", state)
+		breakerTransitionsTotal.inc(state.String())
+	}
+	b.state, b.changed = state, now
+	b.probing, b.probed = 0, 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.record(state)
+}
+
+// Publishes the state in the metrics.
+func (b *circuitBreaker) record(state breakerState) {
+	for s, name := range breakerStateNames {
+		value := 0.0
+		if breakerState(s) == state {
+			value = 1
+		}
+		breakerStateGauge.set(value, name)
+	}
+}
+
+// The circuit breaker around Orchestrate, or nil if it is disabled.
+var upstreamBreaker *circuitBreaker
+
+// An http.RoundTripper that passes requests to Orchestrate through the
+// circuit breaker.
+type breakerTransport struct {
+	next http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := upstreamBreaker
+	if b == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	if !b.allow(time.Now()) {
+		breakerRejectionsTotal.inc()
+		return nil, errBreakerOpen
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() == context.Canceled {
+		b.abandon()
+		return resp, err
+	}
+	b.done(time.Now(), time.Since(start), err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/orchestrate-io/gorc"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testBreaker(t *testing.T, configJSON string) *circuitBreaker {
+	config := new(breakerConfig)
+	if err := json.Unmarshal([]byte(configJSON), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.init(); err != nil {
+		t.Fatal(err)
+	}
+	return newCircuitBreaker(config)
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		latency time.Duration
+		failed  bool
+	}{
+		{"errors", time.Millisecond, true},
+		{"latency", 3 * time.Second, false},
+	} {
+		b := testBreaker(t, `{"min_requests": 4}`)
+		now := time.Now()
+
+		for i := 0; i < 4; i++ {
+			if !b.allow(now) {
+				t.Fatalf("%s: expected call %d to be allowed", test.name, i)
+			}
+			if i < 2 {
+				b.done(now, time.Millisecond, false)
+			} else {
+				b.done(now, test.latency, test.failed)
+			}
+		}
+
+		if state, _ := b.status(now); state != breakerOpen {
+			t.Errorf("%s: expected the breaker to be open, got %s", test.name, state)
+		}
+		if b.allow(now) {
+			t.Errorf("%s: expected an open breaker to refuse calls", test.name)
+		}
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := testBreaker(t, `{"min_requests": 4, "window": "10s"}`)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		b.allow(now)
+		b.done(now, 0, true)
+	}
+
+	// The failures have left the window by the time the fourth call is made.
+	later := now.Add(11 * time.Second)
+	b.allow(later)
+	b.done(later, 0, true)
+	if state, _ := b.status(later); state != breakerClosed {
+		t.Errorf("expected failures outside the window to be forgotten, got %s", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := testBreaker(t, `{"min_requests": 1, "open_for": "30s", "probes": 2}`)
+	now := time.Now()
+
+	b.allow(now)
+	b.done(now, 0, true)
+	if b.allow(now.Add(29 * time.Second)) {
+		t.Fatal("expected the breaker to stay open for open_for")
+	}
+
+	// A failed probe opens the breaker again.
+	now = now.Add(30 * time.Second)
+	if !b.allow(now) {
+		t.Fatal("expected a probe to be allowed once open_for has passed")
+	}
+	b.done(now, 0, true)
+	if state, _ := b.status(now); state != breakerOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %s", state)
+	}
+
+	// Successful probes close it.
+	now = now.Add(30 * time.Second)
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatal("expected both probes to be allowed")
+	}
+	if b.allow(now) {
+		t.Error("expected calls beyond the probes to be refused")
+	}
+	b.done(now, 0, false)
+	if state, _ := b.status(now); state != breakerHalfOpen {
+		t.Errorf("expected the breaker to wait for every probe, got %s", state)
+	}
+	b.done(now, 0, false)
+	if state, _ := b.status(now); state != breakerClosed {
+		t.Errorf("expected successful probes to close the breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerAbandon(t *testing.T) {
+	b := testBreaker(t, `{"min_requests": 1, "open_for": "30s"}`)
+	now := time.Now()
+
+	b.allow(now)
+	b.done(now, 0, true)
+
+	// A probe the client gave up on frees its place for another.
+	now = now.Add(30 * time.Second)
+	b.allow(now)
+	b.abandon()
+	if !b.allow(now) {
+		t.Fatal("expected an abandoned probe to be replaced")
+	}
+	b.done(now, 0, false)
+	if state, _ := b.status(now); state != breakerClosed {
+		t.Errorf("expected the probe to close the breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	for _, configJSON := range []string{
+		`{"error_rate": 1.5}`,
+		`{"min_requests": -1}`,
+		`{"open_for": "-1s"}`,
+		`{"window": "5ns"}`,
+		`{"window": "100ms"}`,
+	} {
+		if _, err := parseConfig([]byte(`{"circuit_breaker": ` + configJSON + `}`)); err == nil {
+			t.Errorf("%s: expected the configuration to be rejected", configJSON)
+		}
+	}
+}
+
+func TestSearchCircuitOpen(t *testing.T) {
+	p := newTestProxy(t, `{
+		"collections": {"people": {"cache_ttl": "10ms", "max_stale": "1m"}},
+		"circuit_breaker": {"min_requests": 3, "error_rate": 0.6, "open_for": "1h"}
+	}`)
+	defer p.Close()
+	seedPeople(p)
+	c = gorc.NewClientWithOptions("", &gorc.ClientOptions{
+		BaseURL:   p.orchestrate.APIURL(),
+		Transport: &breakerTransport{next: http.DefaultTransport},
+	})
+
+	cached := p.get("/people?query=ada", nil)
+	time.Sleep(20 * time.Millisecond)
+
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(500)
+		return true
+	}
+	for i := 0; i < 2; i++ {
+		if w := p.get("/people?query=charles", nil); w.Code != 502 {
+			t.Fatalf("expected the upstream failure to be reported, got %d", w.Code)
+		}
+	}
+	requests := p.orchestrate.Requests()
+
+	w := p.get("/people?query=ada", nil)
+	if w.Code != 200 || w.Header().Get("X-Cache") != "STALE" || w.Body.String() != cached.Body.String() {
+		t.Errorf("expected the expired results to be served, got %d %q", w.Code, w.Header().Get("X-Cache"))
+	}
+
+	w = p.get("/people?query=nobody", nil)
+	if e := decodeError(t, w); w.Code != 503 || e.Code != "upstream_circuit_open" {
+		t.Errorf("expected the open breaker to fail fast, got %d %+v", w.Code, e)
+	}
+	if n := p.orchestrate.Requests() - requests; n != 0 {
+		t.Errorf("expected no calls to Orchestrate while open, got %d", n)
+	}
+
+	var response readyResponse
+	w = p.get("/_ready", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.CircuitBreaker == nil || response.CircuitBreaker.State != "open" {
+		t.Errorf("expected the breaker state in the readiness report, got %s", w.Body.String())
+	}
+	if breakerStateGauge.value("open") != 1 || breakerStateGauge.value("closed") != 0 {
+		t.Error("expected the breaker state in the metrics")
+	}
+}
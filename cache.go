@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bytes charged for each cache entry on top of its key and body.
+const cacheEntryOverhead = 128
+
+// An in-memory LRU cache of encoded responses, bounded by the memory held.
+// Concurrent loads of the same key are coalesced into a single call.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	lru      *list.List
+	flights  map[string]*flight
+}
+
+// A cached response.
+type cacheEntry struct {
+	key     string
+	body    []byte
+	fetched time.Time
+	expires time.Time
+}
+
+// A load in progress. Callers that arrive while it runs wait for its result
+// rather than starting their own.
+type flight struct {
+	done chan struct{}
+	body []byte
+	err  error
+
+	// The callers waiting for the result, and a function canceling the load
+	// once none are left.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Returns a new cache that holds up to maxBytes of responses.
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		flights:  map[string]*flight{},
+	}
+}
+
+// Returns the cache key for a search. The query is normalized so that
+// differences in whitespace do not cause misses.
+func searchCacheKey(collection, query string, limit, offset int, fields []fieldPath) string {
+	return strings.Join([]string{
+		collection,
+		strings.Join(strings.Fields(query), " "),
+		strconv.Itoa(limit),
+		strconv.Itoa(offset),
+		joinFields(fields),
+	}, "\x00")
+}
+
+// Returns the fresh response held for a key.
+func (rc *responseCache) get(key string) ([]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	element, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		return nil, false
+	}
+
+	rc.lru.MoveToFront(element)
+	return entry.body, true
+}
+
+// Returns the response held for a key, whether or not it has expired, and
+// how long ago it was fetched. Responses that expired more than maxStale ago
+// are not returned.
+func (rc *responseCache) stale(key string, maxStale time.Duration) ([]byte, time.Duration, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	element, ok := rc.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	now := time.Now()
+	if now.Sub(entry.expires) > maxStale {
+		return nil, 0, false
+	}
+
+	rc.lru.MoveToFront(element)
+	return entry.body, now.Sub(entry.fetched), true
+}
+
+// Stores a response for ttl, evicting the least recently used responses as
+// needed to stay within the memory bound.
+func (rc *responseCache) set(key string, body []byte, ttl time.Duration) {
+	size := entrySize(key, body)
+	if ttl <= 0 || size > rc.maxBytes {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if element, ok := rc.entries[key]; ok {
+		rc.remove(element)
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{key: key, body: body, fetched: now, expires: now.Add(ttl)}
+	rc.entries[key] = rc.lru.PushFront(entry)
+	rc.bytes += size
+
+	for rc.bytes > rc.maxBytes {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// Removes an entry. The caller must hold the lock.
+func (rc *responseCache) remove(element *list.Element) {
+	entry := rc.lru.Remove(element).(*cacheEntry)
+	delete(rc.entries, entry.key)
+	rc.bytes -= entrySize(entry.key, entry.body)
+}
+
+func entrySize(key string, body []byte) int64 {
+	return int64(len(key) + len(body) + cacheEntryOverhead)
+}
+
+// Returns the response for a key, from the cache if it is fresh, otherwise by
+// calling load and caching its result for ttl. If a load for the key is
+// already running then its result is shared. A caller whose context is done
+// stops waiting, and once every caller has stopped the load's context is
+// canceled. The boolean result reports whether the response came from the
+// cache.
+func (rc *responseCache) fetch(ctx context.Context, key string, ttl time.Duration, load func(context.Context) ([]byte, error)) ([]byte, bool, error) {
+	if body, ok := rc.get(key); ok {
+		return body, true, nil
+	}
+
+	rc.mu.Lock()
+	f, ok := rc.flights[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		rc.flights[key] = f
+		go rc.load(loadCtx, key, ttl, f, load)
+	}
+	f.waiters++
+	rc.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.body, false, f.err
+	case <-ctx.Done():
+	}
+
+	// Callers that arrive after the last one has gone start a new load
+	// rather than joining one that is being canceled.
+	rc.mu.Lock()
+	if f.waiters--; f.waiters == 0 {
+		f.cancel()
+		if rc.flights[key] == f {
+			delete(rc.flights, key)
+		}
+	}
+	rc.mu.Unlock()
+	return nil, false, ctx.Err()
+}
+
+// Runs a flight's load, caching its result and handing it to the callers
+// waiting for it.
+func (rc *responseCache) load(ctx context.Context, key string, ttl time.Duration, f *flight, load func(context.Context) ([]byte, error)) {
+	defer f.cancel()
+
+	f.body, f.err = load(ctx)
+	if f.err == nil {
+		rc.set(key, f.body, ttl)
+	}
+
+	rc.mu.Lock()
+	if rc.flights[key] == f {
+		delete(rc.flights, key)
+	}
+	rc.mu.Unlock()
+	close(f.done)
+}
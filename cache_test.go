@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheEviction(t *testing.T) {
+	rc := newResponseCache(3 * entrySize("k1", make([]byte, 10)))
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		rc.set(key, make([]byte, 10), time.Minute)
+	}
+	rc.get("k1")
+	rc.set("k4", make([]byte, 10), time.Minute)
+
+	if _, ok := rc.get("k2"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"k1", "k3", "k4"} {
+		if _, ok := rc.get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	rc.set("huge", make([]byte, 1000), time.Minute)
+	if _, ok := rc.get("huge"); ok {
+		t.Error("expected an entry larger than the cache to be skipped")
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	rc := newResponseCache(1 << 20)
+
+	rc.set("short", []byte("x"), time.Millisecond)
+	rc.set("none", []byte("x"), 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := rc.get("short"); ok {
+		t.Error("expected the entry to expire")
+	}
+	if _, ok := rc.get("none"); ok {
+		t.Error("expected a zero ttl not to be cached")
+	}
+}
+
+func TestResponseCacheStale(t *testing.T) {
+	rc := newResponseCache(1 << 20)
+	rc.set("key", []byte("body"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := rc.get("key"); ok {
+		t.Fatal("expected the entry to have expired")
+	}
+	if body, age, ok := rc.stale("key", time.Hour); !ok || string(body) != "body" || age < 5*time.Millisecond {
+		t.Errorf("expected the expired entry and its age since it was fetched, got %q %s %v", body, age, ok)
+	}
+	if _, _, ok := rc.stale("key", time.Millisecond); ok {
+		t.Error("expected an entry older than max_stale to be refused")
+	}
+}
+
+func TestResponseCacheCoalescing(t *testing.T) {
+	rc := newResponseCache(1 << 20)
+	release := make(chan struct{})
+	calls := 0
+
+	load := func(context.Context) ([]byte, error) {
+		calls++
+		<-release
+		return []byte("body"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, _, err := rc.fetch(context.Background(), "key", time.Minute, load); err != nil || string(body) != "body" {
+				t.Errorf("unexpected result %q, %v", body, err)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected a single load, got %d", calls)
+	}
+	if _, hit, _ := rc.fetch(context.Background(), "key", time.Minute, load); !hit {
+		t.Error("expected a hit after the load completed")
+	}
+}
+
+func TestResponseCacheCancelsAbandonedLoads(t *testing.T) {
+	rc := newResponseCache(1 << 20)
+	started, canceled := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	first, leaveFirst := context.WithCancel(context.Background())
+	second, leaveSecond := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { _, _, err := rc.fetch(first, "key", time.Minute, load); errs <- err }()
+	<-started
+	go func() { _, _, err := rc.fetch(second, "key", time.Minute, load); errs <- err }()
+	time.Sleep(10 * time.Millisecond)
+
+	// The load carries on while anyone is still waiting for it.
+	leaveFirst()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the caller that left to get %v, got %v", context.Canceled, err)
+	}
+	select {
+	case <-canceled:
+		t.Fatal("expected the load to continue for the remaining caller")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	leaveSecond()
+	<-errs
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the load to be canceled once every caller had left")
+	}
+
+	// A new caller starts a new load.
+	body, _, err := rc.fetch(context.Background(), "key", time.Minute, func(context.Context) ([]byte, error) { return []byte("body"), nil })
+	if err != nil || string(body) != "body" {
+		t.Errorf("expected a new load, got %q, %v", body, err)
+	}
+}
+
+func TestResponseCacheErrorsNotCached(t *testing.T) {
+	rc := newResponseCache(1 << 20)
+	failure := errors.New("failed")
+
+	if _, _, err := rc.fetch(context.Background(), "key", time.Minute, func(context.Context) ([]byte, error) { return nil, failure }); err != failure {
+		t.Fatalf("expected the load error, got %v", err)
+	}
+	if _, ok := rc.get("key"); ok {
+		t.Error("expected errors not to be cached")
+	}
+}
+
+func TestSearchCacheKey(t *testing.T) {
+	if searchCacheKey("people", " name:ada  AND age:36 ", 10, 0, nil) != searchCacheKey("people", "name:ada AND age:36", 10, 0, nil) {
+		t.Error("expected whitespace differences to be normalized")
+	}
+	if searchCacheKey("people", "ada", 10, 0, nil) == searchCacheKey("people", "ada", 10, 10, nil) {
+		t.Error("expected offsets to be distinguished")
+	}
+	if searchCacheKey("people", "ada", 10, 0, nil) == searchCacheKey("people", "ada", 10, 0, splitPaths([]string{"name"})) {
+		t.Error("expected fields to be distinguished")
+	}
+}
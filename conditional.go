@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/hoisie/web"
+	"strings"
+)
+
+// Returns a strong entity tag for a response body.
+func etag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Reports whether an If-None-Match header matches an entity tag. As required
+// for If-None-Match, weak tags compare equal to their strong counterparts.
+func etagMatches(ifNoneMatch, tag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the caching headers for a successful response under a collection's
+// policy, and answers 304 if the client already holds the body. It reports
+// whether the response has been written.
+func writeConditional(ctx *web.Context, policy *collectionPolicy, body []byte) bool {
+	tag := etag(body)
+	ctx.SetHeader("ETag", tag, true)
+	if policy.CacheControl != "" {
+		ctx.SetHeader("Cache-Control", policy.CacheControl, true)
+	}
+	if len(policy.Vary) > 0 {
+		ctx.SetHeader("Vary", strings.Join(policy.Vary, ", "), true)
+	}
+
+	if etagMatches(ctx.Request.Header.Get("If-None-Match"), tag) {
+		ctx.NotModified()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/orchestrate-io/gorc"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The page size used when neither the request nor the collection policy
+	// specifies one.
+	defaultLimit = 10
+
+	// The largest page size Orchestrate will return for a search.
+	maxLimit = 100
+
+	// The query used when neither the request nor the collection policy
+	// specifies one.
+	defaultQuery = "*"
+
+	// The User-Agent sent to Orchestrate.
+	userAgent = "orchestrate-heroku-search"
+
+	// The memory used for cached responses when the configuration does not
+	// specify a size.
+	defaultCacheSize = 16 << 20
+)
+
+// The proxy configuration. It is read at startup from the JSON document held
+// in the CONFIG environment variable, or from the file named by CONFIG_FILE.
+type config struct {
+	// The collections that may be searched through the proxy, keyed by name.
+	// Any collection not listed here is reported as not found.
+	Collections map[string]*collectionPolicy `json:"collections"`
+
+	// The approximate number of bytes of memory used to cache responses.
+	CacheSize int64 `json:"cache_size"`
+
+	// The rate limit applied to collections that do not set their own. If
+	// nil then such collections are not limited.
+	RateLimit *rateLimit `json:"rate_limit"`
+
+	// The number of proxies in front of the application that append to
+	// X-Forwarded-For. On Heroku this is the router, so it defaults to 1.
+	// Zero means clients connect directly.
+	ProxyHops *int `json:"proxy_hops"`
+
+	// The access tiers, keyed by name. Requests without an API key are
+	// served under the "anonymous" tier, which by default is restricted:
+	// see defaultAnonymous.
+	Tiers map[string]*tier `json:"tiers"`
+
+	// The public collections the default anonymous tier leaves out because
+	// other tiers name them. Empty when the anonymous tier is configured.
+	anonymousGated []string
+
+	// API keys, keyed by the key itself.
+	Keys map[string]*apiKey `json:"keys"`
+
+	// A private collection holding further API keys, keyed by the key
+	// itself. It is reloaded every KeysRefresh, so keys can be issued and
+	// revoked without a restart.
+	KeysCollection string   `json:"keys_collection"`
+	KeysRefresh    duration `json:"keys_refresh"`
+
+	// The secret used to verify signed URLs. Signed URLs are refused when it
+	// is empty.
+	SigningSecret string `json:"signing_secret"`
+
+	// The absolute URL clients reach the proxy at, e.g.
+	// https://search.example.com. If empty then it is worked out from each
+	// request's Host and X-Forwarded-Proto headers.
+	PublicURL string `json:"public_url"`
+
+	// The secret used to protect paging cursors. If empty then the
+	// signing_secret is used, or failing that a random secret, in which case
+	// cursors only work with the process that issued them.
+	CursorSecret string `json:"cursor_secret"`
+
+	cursorKey []byte
+
+	// How log lines are written.
+	Log *logConfig `json:"log"`
+
+	// How long the readiness check waits for Orchestrate, and how long its
+	// result is reused.
+	ReadyTimeout  duration `json:"ready_timeout"`
+	ReadyCacheTTL duration `json:"ready_cache_ttl"`
+
+	// How long in-flight requests may run after a SIGTERM before they are
+	// aborted.
+	ShutdownGrace duration `json:"shutdown_grace"`
+
+	// When the circuit breaker around Orchestrate trips.
+	Breaker *breakerConfig `json:"circuit_breaker"`
+}
+
+// The exposure policy of a single public collection.
+type collectionPolicy struct {
+	// Disabled collections are reported as not found, exactly as if they
+	// were not listed at all.
+	Disabled bool `json:"disabled"`
+
+	// The page size used when the request does not specify a limit.
+	DefaultLimit int `json:"default_limit"`
+
+	// The largest page size a request may ask for. Larger limits are
+	// clamped to this value.
+	MaxLimit int `json:"max_limit"`
+
+	// The query used when the request's query parameter is empty.
+	DefaultQuery string `json:"default_query"`
+
+	// How long search responses are cached for. Zero disables caching.
+	CacheTTL duration `json:"cache_ttl"`
+
+	// How long after they expire cached responses may still be served when
+	// Orchestrate fails. Zero disables serving stale responses.
+	MaxStale duration `json:"max_stale"`
+
+	// The Cache-Control header sent with search responses, if any.
+	CacheControl string `json:"cache_control"`
+
+	// The request headers listed in the Vary header of search responses.
+	Vary []string `json:"vary"`
+
+	// The rate limit applied to each client searching this collection. If
+	// nil then the top level rate limit applies.
+	RateLimit *rateLimit `json:"rate_limit"`
+
+	// Signed only collections can only be searched through signed URLs;
+	// unsigned requests are refused with a 403.
+	SignedOnly bool `json:"signed_only"`
+
+	// The rules client supplied queries must follow.
+	QueryRules *queryRules `json:"query_rules"`
+
+	// The deepest offset a search may reach, whether through the offset
+	// parameter or a cursor.
+	MaxOffset int `json:"max_offset"`
+
+	// How the collection is presented in its OpenSearch description and
+	// Atom feeds.
+	Feed *feedConfig `json:"feed"`
+
+	// Whether searches may ask for JSONP with a callback parameter.
+	JSONP bool `json:"jsonp"`
+
+	// The most rows a CSV or NDJSON export may contain.
+	MaxExportRows int `json:"max_export_rows"`
+
+	// Dot separated paths that are always stripped from result values,
+	// whatever fields the client selects.
+	Redact []string `json:"redact"`
+
+	redact []fieldPath
+}
+
+// A time.Duration that is written in JSON as a string such as "30s", or as a
+// number of seconds.
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+
+	if d.Duration < 0 {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// Loads the configuration from the environment.
+func loadConfig() (*config, error) {
+	var data []byte
+	if raw := os.Getenv("CONFIG"); raw != "" {
+		data = []byte(raw)
+	} else if file := os.Getenv("CONFIG_FILE"); file != "" {
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+	} else {
+		data = []byte("{}")
+	}
+
+	return parseConfig(data)
+}
+
+// Returns an Orchestrate client configured from the environment. ORC_KEY is
+// the API key, ORC_API_URL optionally overrides the API location and
+// ORC_TIMEOUT optionally limits the duration of each call (e.g. "5s").
+func newClient() (*gorc.Client, error) {
+	options := &gorc.ClientOptions{
+		BaseURL:   os.Getenv("ORC_API_URL"),
+		Transport: &breakerTransport{next: &metricsTransport{next: http.DefaultTransport}},
+		UserAgent: userAgent,
+		Retry:     new(gorc.RetryPolicy),
+	}
+
+	if attempts := os.Getenv("ORC_MAX_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid ORC_MAX_ATTEMPTS: %q", attempts)
+		}
+		options.Retry.MaxAttempts = n
+	}
+
+	if timeout := os.Getenv("ORC_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ORC_TIMEOUT: %s", err)
+		}
+		options.Timeout = d
+	}
+
+	return gorc.NewClientWithOptions(os.Getenv("ORC_KEY"), options), nil
+}
+
+// Parses a JSON configuration document and fills in defaults.
+func parseConfig(data []byte) (*config, error) {
+	conf := new(config)
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err)
+	}
+
+	if conf.Collections == nil {
+		conf.Collections = map[string]*collectionPolicy{}
+	}
+	if conf.CacheSize == 0 {
+		conf.CacheSize = defaultCacheSize
+	}
+	if conf.ProxyHops == nil {
+		hops := 1
+		conf.ProxyHops = &hops
+	} else if *conf.ProxyHops < 0 {
+		return nil, fmt.Errorf("proxy_hops must not be negative")
+	}
+	if conf.RateLimit != nil {
+		if err := conf.RateLimit.init(); err != nil {
+			return nil, fmt.Errorf("rate_limit: %s", err)
+		}
+	}
+
+	if conf.Tiers == nil {
+		conf.Tiers = map[string]*tier{}
+	}
+	if conf.Tiers[anonymousTier] == nil {
+		conf.Tiers[anonymousTier], conf.anonymousGated = defaultAnonymous(conf)
+	}
+	for name, t := range conf.Tiers {
+		if t == nil {
+			t = new(tier)
+			conf.Tiers[name] = t
+		}
+		if err := t.init(); err != nil {
+			return nil, fmt.Errorf("tier %q: %s", name, err)
+		}
+	}
+
+	for key, k := range conf.Keys {
+		if k == nil || conf.Tiers[k.Tier] == nil {
+			return nil, fmt.Errorf("API key %q has an unknown tier", key)
+		}
+	}
+	if conf.KeysCollection != "" && conf.Collections[conf.KeysCollection] != nil {
+		return nil, fmt.Errorf("the keys collection %q must not be public", conf.KeysCollection)
+	}
+	if conf.KeysRefresh.Duration == 0 {
+		conf.KeysRefresh.Duration = defaultKeysRefresh
+	}
+
+	if conf.ReadyTimeout.Duration == 0 {
+		conf.ReadyTimeout.Duration = defaultReadyTimeout
+	}
+	if conf.ReadyCacheTTL.Duration == 0 {
+		conf.ReadyCacheTTL.Duration = defaultReadyCacheTTL
+	}
+
+	if conf.ShutdownGrace.Duration == 0 {
+		conf.ShutdownGrace.Duration = defaultShutdownGrace
+	}
+
+	if conf.Breaker == nil {
+		conf.Breaker = new(breakerConfig)
+	}
+	if err := conf.Breaker.init(); err != nil {
+		return nil, fmt.Errorf("circuit_breaker: %s", err)
+	}
+
+	if conf.Log == nil {
+		conf.Log = new(logConfig)
+	}
+	if err := conf.Log.init(); err != nil {
+		return nil, fmt.Errorf("log: %s", err)
+	}
+
+	switch {
+	case conf.CursorSecret != "":
+		conf.cursorKey = []byte(conf.CursorSecret)
+	case conf.SigningSecret != "":
+		conf.cursorKey = []byte(conf.SigningSecret)
+	default:
+		conf.cursorKey = make([]byte, 32)
+		if _, err := rand.Read(conf.cursorKey); err != nil {
+			return nil, fmt.Errorf("failed to generate a cursor secret: %s", err)
+		}
+	}
+
+	for name, policy := range conf.Collections {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid collection name %q", name)
+		}
+		if name == "metrics" || strings.HasPrefix(name, "_") {
+			return nil, fmt.Errorf("the collection name %q is reserved", name)
+		}
+		if policy == nil {
+			policy = new(collectionPolicy)
+			conf.Collections[name] = policy
+		}
+		if err := policy.init(conf); err != nil {
+			return nil, fmt.Errorf("collection %q: %s", name, err)
+		}
+		if policy.Feed == nil {
+			policy.Feed = new(feedConfig)
+		}
+		if err := policy.Feed.init(name); err != nil {
+			return nil, fmt.Errorf("collection %q: feed: %s", name, err)
+		}
+	}
+
+	return conf, nil
+}
+
+// Fills in defaults for unset fields, including those inherited from the top
+// level configuration, and validates the policy.
+func (p *collectionPolicy) init(conf *config) error {
+	if p.MaxLimit == 0 {
+		p.MaxLimit = maxLimit
+	}
+	if p.DefaultLimit == 0 {
+		p.DefaultLimit = defaultLimit
+	}
+	if p.DefaultQuery == "" {
+		p.DefaultQuery = defaultQuery
+	}
+
+	if p.MaxLimit < 1 || p.MaxLimit > maxLimit {
+		return fmt.Errorf("max_limit must be between 1 and %d", maxLimit)
+	}
+	if p.DefaultLimit < 1 {
+		return fmt.Errorf("default_limit must be positive")
+	}
+	if p.DefaultLimit > p.MaxLimit {
+		p.DefaultLimit = p.MaxLimit
+	}
+	if p.MaxOffset == 0 {
+		p.MaxOffset = defaultMaxOffset
+	}
+	if p.MaxOffset < 0 {
+		return fmt.Errorf("max_offset must not be negative")
+	}
+	// Exports page no deeper than max_offset, so no more rows than that
+	// reaches can be exported.
+	reachable := p.MaxOffset + p.MaxLimit
+	if p.MaxExportRows == 0 {
+		p.MaxExportRows = defaultMaxExportRows
+		if p.MaxExportRows > reachable {
+			p.MaxExportRows = reachable
+		}
+	}
+	if p.MaxExportRows < 0 {
+		return fmt.Errorf("max_export_rows must not be negative")
+	}
+	if p.MaxExportRows > reachable {
+		return fmt.Errorf("max_export_rows can be at most %d, the rows up to max_offset", reachable)
+	}
+	if p.MaxStale.Duration > 0 && p.CacheTTL.Duration == 0 {
+		return fmt.Errorf("max_stale requires cache_ttl, since only cached responses can be served stale")
+	}
+
+	if p.QueryRules == nil {
+		p.QueryRules = new(queryRules)
+	}
+	if err := p.QueryRules.init(); err != nil {
+		return fmt.Errorf("query_rules: %s", err)
+	}
+
+	for _, name := range p.Redact {
+		if _, err := parseFields(name); err != nil {
+			return fmt.Errorf("redact: %s", err)
+		}
+	}
+	p.redact = splitPaths(p.Redact)
+
+	if p.SignedOnly && conf.SigningSecret == "" {
+		return fmt.Errorf("signed_only requires a signing_secret")
+	}
+
+	if p.RateLimit == nil {
+		p.RateLimit = conf.RateLimit
+	} else if err := p.RateLimit.init(); err != nil {
+		return fmt.Errorf("rate_limit: %s", err)
+	}
+
+	return nil
+}
+
+// Returns the policy for a collection, or nil if the collection is not
+// publicly searchable.
+func (conf *config) collection(name string) *collectionPolicy {
+	policy := conf.Collections[name]
+	if policy == nil || policy.Disabled {
+		return nil
+	}
+	return policy
+}
+
+// Returns the page size to use for a requested limit.
+func (p *collectionPolicy) limit(requested string) int {
+	limit, err := strconv.Atoi(requested)
+	if err != nil || limit < 1 {
+		return p.DefaultLimit
+	}
+	if limit > p.MaxLimit {
+		return p.MaxLimit
+	}
+	return limit
+}
+
+// Returns the query to use for a requested query.
+func (p *collectionPolicy) query(requested string) string {
+	if strings.TrimSpace(requested) == "" {
+		return p.DefaultQuery
+	}
+	return requested
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseConfigDefaults(t *testing.T) {
+	conf, err := parseConfig([]byte(`{"collections": {"users": {}, "notes": {"disabled": true}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := conf.collection("users")
+	if policy == nil {
+		t.Fatal("expected users to be public")
+	}
+	if policy.DefaultLimit != defaultLimit || policy.MaxLimit != maxLimit || policy.DefaultQuery != defaultQuery {
+		t.Errorf("unexpected defaults: %+v", policy)
+	}
+	if policy.MaxExportRows != defaultMaxOffset+maxLimit {
+		t.Errorf("expected exports to default to the rows up to max_offset, got %d", policy.MaxExportRows)
+	}
+
+	if conf.collection("notes") != nil {
+		t.Error("disabled collection should not be public")
+	}
+	if conf.collection("secrets") != nil {
+		t.Error("unlisted collection should not be public")
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"collections": {"a/b": {}}}`,
+		`{"collections": {"users": {"max_limit": 101}}}`,
+		`{"collections": {"users": {"default_limit": -1}}}`,
+		`{"collections": {"users": {"max_stale": "1m"}}}`,
+		`{"collections": {"users": {"max_offset": 10, "max_export_rows": 1000}}}`,
+		`{"collections": []}`,
+	} {
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Errorf("expected %s to be rejected", data)
+		}
+	}
+}
+
+func TestCollectionPolicyLimit(t *testing.T) {
+	policy := &collectionPolicy{DefaultLimit: 5, MaxLimit: 20}
+
+	for requested, expected := range map[string]int{
+		"":    5,
+		"abc": 5,
+		"0":   5,
+		"-3":  5,
+		"7":   7,
+		"20":  20,
+		"500": 20,
+	} {
+		if limit := policy.limit(requested); limit != expected {
+			t.Errorf("limit(%q) = %d, expected %d", requested, limit, expected)
+		}
+	}
+}
+
+func TestCollectionPolicyQuery(t *testing.T) {
+	policy := &collectionPolicy{DefaultQuery: "published:true"}
+
+	if q := policy.query("  "); q != "published:true" {
+		t.Errorf("expected default query, got %q", q)
+	}
+	if q := policy.query("name:bob"); q != "name:bob" {
+		t.Errorf("expected requested query, got %q", q)
+	}
+}
+
+func TestNewClientEnvironment(t *testing.T) {
+	t.Setenv("ORC_MAX_ATTEMPTS", "2")
+	if _, err := newClient(); err != nil {
+		t.Errorf("expected a valid ORC_MAX_ATTEMPTS to be accepted: %s", err)
+	}
+
+	for _, value := range []string{"0", "many"} {
+		t.Setenv("ORC_MAX_ATTEMPTS", value)
+		if _, err := newClient(); err == nil {
+			t.Errorf("expected ORC_MAX_ATTEMPTS=%s to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// The deepest offset a search may reach when the collection does not say.
+const defaultMaxOffset = 1000
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// A position in a search, handed to clients as an opaque token so that they
+// can page through results without being able to change the search.
+type cursor struct {
+	Collection string `json:"c"`
+	Query      string `json:"q"`
+	Limit      int    `json:"l"`
+	Offset     int    `json:"o,omitempty"`
+
+	// Whether the cursor came from a signed search, and when that search's
+	// signature expires, in seconds since the epoch. Such cursors are only
+	// good through a signed URL, so they can't outlive it or escape the
+	// query rules signing waived.
+	Signed  bool  `json:"s,omitempty"`
+	Expires int64 `json:"e,omitempty"`
+}
+
+// Encodes a cursor as a token: its JSON and an HMAC-SHA256 of the JSON, each
+// base64 encoded and joined with a dot.
+func encodeCursor(key []byte, cur *cursor) string {
+	payload, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(cursorMAC(key, payload))
+}
+
+// Decodes a token made by encodeCursor, checking that it has not been
+// altered.
+func decodeCursor(key []byte, token string) (*cursor, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return nil, errInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:dot])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil || !hmac.Equal(mac, cursorMAC(key, payload)) {
+		return nil, errInvalidCursor
+	}
+
+	cur := new(cursor)
+	if err := json.Unmarshal(payload, cur); err != nil || cur.Limit < 1 || cur.Offset < 0 {
+		return nil, errInvalidCursor
+	}
+	return cur, nil
+}
+
+func cursorMAC(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("secret")
+	cur := &cursor{Collection: "people", Query: "name:ada", Limit: 10, Offset: 20}
+	token := encodeCursor(key, cur)
+
+	decoded, err := decodeCursor(key, token)
+	if err != nil || *decoded != *cur {
+		t.Fatalf("expected %+v, got %+v (%v)", cur, decoded, err)
+	}
+
+	tampered := encodeCursor(key, &cursor{Collection: "people", Query: "*", Limit: 10, Offset: 20})
+	tampered = tampered[:strings.IndexByte(tampered, '.')] + token[strings.IndexByte(token, '.'):]
+	for _, bad := range []string{"", "garbage", token + "x", tampered} {
+		if _, err := decodeCursor(key, bad); err != errInvalidCursor {
+			t.Errorf("decodeCursor(%q): expected errInvalidCursor, got %v", bad, err)
+		}
+	}
+	if _, err := decodeCursor([]byte("other"), token); err != errInvalidCursor {
+		t.Errorf("expected a cursor from another secret to be refused, got %v", err)
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	first := decodeResults(t, p.get("/people?limit=1", nil))
+	next, _ := url.Parse(first.Next)
+	second := decodeResults(t, p.get(next.RequestURI()+"&query=*&offset=0", nil))
+	if second.Count != 1 || second.Results[0].Path.Key == first.Results[0].Path.Key {
+		t.Errorf("expected the cursor to select the second page, got %+v", second)
+	}
+
+	token := encodeCursor(conf.cursorKey, &cursor{Collection: "limited", Query: "*", Limit: 1})
+	w := p.get("/people?cursor="+url.QueryEscape(token), nil)
+	if e := decodeError(t, w); w.Code != 400 || e.Code != "invalid_cursor" {
+		t.Errorf("expected a cursor for another collection to be refused, got %d %+v", w.Code, e)
+	}
+}
+
+func TestSearchMaxOffset(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {"people": {"max_offset": 2}}}`)
+	defer p.Close()
+	seedPeople(p)
+
+	if w := p.get("/people?limit=1&offset=2", nil); w.Code != 200 {
+		t.Errorf("expected offset 2 to be allowed, got %d", w.Code)
+	} else if results := decodeResults(t, w); results.Next != "" {
+		t.Errorf("expected no next link beyond the maximum offset, got %q", results.Next)
+	}
+
+	w := p.get("/people?offset=3", nil)
+	if e := decodeError(t, w); w.Code != 400 || e.Code != "offset_too_large" {
+		t.Errorf("expected offset_too_large, got %d %+v", w.Code, e)
+	}
+
+	token := encodeCursor(conf.cursorKey, &cursor{Collection: "people", Query: "*", Limit: 1, Offset: 1000})
+	if w := p.get("/people?cursor="+url.QueryEscape(token), nil); w.Code != 400 {
+		t.Errorf("expected a cursor beyond the maximum offset to be refused, got %d", w.Code)
+	}
+}
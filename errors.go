@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// An error reported to clients of the proxy. Every endpoint reports failures
+// with the same envelope: {"error": {"status": ..., "code": ..., ...}}.
+type apiError struct {
+	// The HTTP status the error is served with.
+	Status int `json:"status"`
+
+	// A stable, machine readable identifier for the class of failure.
+	Code string `json:"code"`
+
+	// A human readable description that is safe to show to anyone.
+	Message string `json:"message"`
+
+	// The id of the request that failed, for correlation with the logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// The body of an error response.
+type errorEnvelope struct {
+	Error *apiError `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+// Returns a new apiError.
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+func errNotFound() *apiError {
+	return newAPIError(404, "not_found", "The requested resource could not be found.")
+}
+
+func errCollectionNotFound() *apiError {
+	return newAPIError(404, "collection_not_found", "The requested collection could not be found.")
+}
+
+// Maps an error returned by gorc onto the error reported to the client. The
+// details of the upstream failure are never exposed; they are logged instead.
+func upstreamError(err error) *apiError {
+	switch e := err.(type) {
+	case *apiError:
+		return e
+	case *gorc.OrchestrateError:
+		return orchestrateError(e.StatusCode)
+	case gorc.OrchestrateError:
+		return orchestrateError(e.StatusCode)
+	case *url.Error:
+		if e.Err == errBreakerOpen {
+			return errCircuitOpen()
+		}
+		if e.Timeout() {
+			return errUpstreamTimeout()
+		}
+		return connectError(e.Err)
+	case net.Error:
+		if e.Timeout() {
+			return errUpstreamTimeout()
+		}
+		return errUpstreamUnavailable()
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return errBadUpstreamResponse()
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errBadUpstreamResponse()
+	}
+
+	return newAPIError(500, "internal_error", "An unexpected error occurred.")
+}
+
+// Maps a failure to reach Orchestrate onto the error reported to the client.
+func connectError(err error) *apiError {
+	switch e := err.(type) {
+	case net.Error:
+		if e.Timeout() {
+			return errUpstreamTimeout()
+		}
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError, tls.RecordHeaderError:
+		return newAPIError(502, "upstream_tls_error", "A secure connection to the search service could not be established.")
+	}
+	return errUpstreamUnavailable()
+}
+
+// Maps an Orchestrate status code onto the error reported to the client.
+// Client errors are passed through, except for authorization failures which
+// mean the proxy itself is misconfigured.
+func orchestrateError(status int) *apiError {
+	switch {
+	case status == 400:
+		return newAPIError(400, "invalid_query", "The search query could not be processed.")
+	case status == 401 || status == 403:
+		return newAPIError(502, "upstream_unauthorized", "The search service rejected the proxy's credentials.")
+	case status == 404:
+		return errCollectionNotFound()
+	case status == 429:
+		return newAPIError(429, "upstream_rate_limited", "The search service is receiving too many requests.")
+	case status >= 400 && status < 500:
+		return newAPIError(status, "upstream_rejected", http.StatusText(status))
+	}
+	return newAPIError(502, "upstream_error", "The search service failed to process the request.")
+}
+
+func errUpstreamTimeout() *apiError {
+	return newAPIError(504, "upstream_timeout", "The search service did not respond in time.")
+}
+
+func errUpstreamUnavailable() *apiError {
+	return newAPIError(502, "upstream_unavailable", "The search service could not be reached.")
+}
+
+func errCircuitOpen() *apiError {
+	return newAPIError(503, "upstream_circuit_open", "The search service is failing; requests to it are paused.")
+}
+
+func errBadUpstreamResponse() *apiError {
+	return newAPIError(502, "upstream_bad_response", "The search service returned an invalid response.")
+}
+
+func errShuttingDown() *apiError {
+	return newAPIError(503, "shutting_down", "The server is shutting down; retry the request.")
+}
+
+// Nobody sees this error; it records in the access log and metrics that the
+// client went away before it could be answered.
+func errClientGone() *apiError {
+	return newAPIError(499, "client_closed_request", "The client closed the connection before the response was ready.")
+}
+
+// Writes an error response using the standard envelope.
+func writeError(ctx *web.Context, e *apiError) {
+	e.RequestID = requestID(ctx)
+	writeJSON(ctx, e.Status, &errorEnvelope{Error: e})
+}
+
+// Reports an error returned by gorc to the client and logs its details.
+func writeUpstreamError(ctx *web.Context, err error) {
+	e := upstreamError(err)
+	logError(ctx, "upstream error, responding %d: %s", e.Status, err)
+	writeError(ctx, e)
+}
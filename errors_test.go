@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/orchestrate-io/gorc"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestUpstreamError(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	for _, test := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&gorc.OrchestrateError{StatusCode: 400}, 400, "invalid_query"},
+		{&gorc.OrchestrateError{StatusCode: 401}, 502, "upstream_unauthorized"},
+		{&gorc.OrchestrateError{StatusCode: 404}, 404, "collection_not_found"},
+		{&gorc.OrchestrateError{StatusCode: 429}, 429, "upstream_rate_limited"},
+		{&gorc.OrchestrateError{StatusCode: 409}, 409, "upstream_rejected"},
+		{&gorc.OrchestrateError{StatusCode: 500}, 502, "upstream_error"},
+		{&url.Error{Op: "Get", URL: "https://example", Err: timeoutError{}}, 504, "upstream_timeout"},
+		{&url.Error{Op: "Get", URL: "https://example", Err: dialErr}, 502, "upstream_unavailable"},
+		{&json.SyntaxError{}, 502, "upstream_bad_response"},
+		{io.ErrUnexpectedEOF, 502, "upstream_bad_response"},
+		{errors.New("boom"), 500, "internal_error"},
+	} {
+		e := upstreamError(test.err)
+		if e.Status != test.status || e.Code != test.code {
+			t.Errorf("upstreamError(%#v) = %d %s, expected %d %s", test.err, e.Status, e.Code, test.status, test.code)
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	if !validRequestID("f9ed4675-f4a2-4c3e-8f4b-2d1a1b6a7e3c") {
+		t.Error("expected a uuid to be accepted")
+	}
+	if validRequestID("") || validRequestID("bad\nid") || validRequestID("<script>") {
+		t.Error("expected invalid ids to be rejected")
+	}
+	if len(newRequestID()) != 32 {
+		t.Error("expected generated ids to be 32 hex characters")
+	}
+}
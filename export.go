@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most rows an export may contain when the collection does not say, and
+// max_offset allows that many.
+const defaultMaxExportRows = 10000
+
+// The trailer telling clients why an export was cut short: the limit it
+// reached, or the error that stopped it.
+const truncatedTrailer = "X-Export-Truncated"
+
+// A format search results can be exported in.
+type exportFormat interface {
+	// Sets the response headers and writes anything preceding the results.
+	begin(ctx *web.Context)
+
+	// Writes a single result. Results that can't be written are skipped.
+	write(result *gorc.SearchResult) error
+
+	// Sends everything written so far to the client, returning any error
+	// writing to it.
+	flush() error
+
+	// Reports an upstream error that stopped the export partway through.
+	fail(ctx *web.Context, err error)
+}
+
+// Streams every result of a search. Pages are fetched from Orchestrate and
+// written one at a time, so the export is never held in memory. The export
+// stops at the collection's row cap or maximum offset, like any other paging,
+// or as soon as the client goes away or shutdown gives up on it, in which case
+// the call to Orchestrate in progress is canceled.
+func export(ctx *web.Context, req *searchRequest, format exportFormat) {
+	// Exports always fetch the largest pages the caller may have.
+	pageSize := req.caller.tier.clamp(req.policy.MaxLimit)
+	entry := accessEntryOf(ctx)
+	entry.limit = pageSize
+
+	start := time.Now()
+	results, err := c.SearchContext(ctx.Request.Context(), req.collection, req.query, pageSize, req.offset)
+	entry.upstream += time.Since(start)
+	if err != nil && inflight.aborted() {
+		logError(ctx, "export of %s aborted: shutting down", req.collection)
+		writeError(ctx, errShuttingDown())
+		return
+	}
+	if err != nil && ctx.Request.Context().Err() != nil {
+		logInfo(ctx, "export of %s aborted: client went away", req.collection)
+		return
+	}
+	if err != nil {
+		writeUpstreamError(ctx, err)
+		return
+	}
+
+	ctx.SetHeader("Trailer", truncatedTrailer, true)
+	format.begin(ctx)
+
+	rows, offset := 0, req.offset
+	defer func(totalCount int) { entry.results(rows, totalCount) }(int(results.TotalCount))
+	for {
+		for i := range results.Results {
+			if rows >= req.policy.MaxExportRows {
+				logInfo(ctx, "export of %s stopped at %d rows", req.collection, rows)
+				truncated(ctx, "max_export_rows")
+				format.flush()
+				return
+			}
+			if err := format.write(&results.Results[i]); err != nil {
+				logError(ctx, "export of %s skipped %s: %s", req.collection, results.Results[i].Path.Key, err)
+				continue
+			}
+			rows++
+		}
+
+		if err := format.flush(); err != nil {
+			logInfo(ctx, "export of %s aborted after %d rows: %s", req.collection, rows, err)
+			return
+		}
+		if !results.HasNext() {
+			return
+		}
+		if offset += pageSize; offset > req.policy.MaxOffset {
+			logInfo(ctx, "export of %s stopped at offset %d", req.collection, req.policy.MaxOffset)
+			truncated(ctx, "max_offset")
+			return
+		}
+		if inflight.aborted() {
+			logError(ctx, "export of %s aborted after %d rows: shutting down", req.collection, rows)
+			truncated(ctx, "shutting_down")
+			format.fail(ctx, errShuttingDown())
+			format.flush()
+			return
+		}
+		if err := ctx.Request.Context().Err(); err != nil {
+			logInfo(ctx, "export of %s aborted after %d rows: client went away", req.collection, rows)
+			return
+		}
+
+		start = time.Now()
+		results, err = c.SearchGetNextContext(ctx.Request.Context(), results)
+		entry.upstream += time.Since(start)
+		if err != nil && inflight.aborted() {
+			logError(ctx, "export of %s aborted after %d rows: shutting down", req.collection, rows)
+			truncated(ctx, "shutting_down")
+			format.fail(ctx, errShuttingDown())
+			format.flush()
+			return
+		}
+		if err != nil && ctx.Request.Context().Err() != nil {
+			logInfo(ctx, "export of %s aborted after %d rows: client went away", req.collection, rows)
+			return
+		}
+		if err != nil {
+			logError(ctx, "export of %s failed after %d rows: %s", req.collection, rows, err)
+			truncated(ctx, upstreamError(err).Code)
+			format.fail(ctx, err)
+			format.flush()
+			return
+		}
+	}
+}
+
+// Sets the trailer of an export that was cut short.
+func truncated(ctx *web.Context, reason string) {
+	ctx.ResponseWriter.Header().Set(truncatedTrailer, reason)
+}
+
+// Sends the response written so far to the client.
+func flushResponse(ctx *web.Context) {
+	if f, ok := ctx.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Exports search results as CSV, one row per result and one column per
+// requested path.
+func exportCSV(ctx *web.Context, collection string) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+
+	req := admitSearch(ctx, collection)
+	if req == nil {
+		return
+	}
+
+	names, err := parseFieldNames(ctx.Params["columns"])
+	if err == nil && len(names) == 0 {
+		err = fmt.Errorf("no columns were given")
+	}
+	if err != nil {
+		writeError(ctx, newAPIError(400, "invalid_columns", fmt.Sprintf("The columns parameter is not valid: %s.", err)))
+		return
+	}
+
+	export(ctx, req, &csvExport{collection: req.collection, names: names, columns: splitPaths(names), redact: req.policy.redact})
+}
+
+type csvExport struct {
+	collection string
+
+	ctx     *web.Context
+	w       *csv.Writer
+	names   []string
+	columns []fieldPath
+	redact  []fieldPath
+}
+
+func (e *csvExport) begin(ctx *web.Context) {
+	ctx.SetHeader("Content-Type", "text/csv; charset=utf-8", true)
+	ctx.SetHeader("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.collection+".csv"), true)
+	ctx.WriteHeader(200)
+
+	e.ctx = ctx
+	e.w = csv.NewWriter(ctx.ResponseWriter)
+	header := make([]string, len(e.names))
+	for i, name := range e.names {
+		header[i] = escapeFormula(name)
+	}
+	e.w.Write(header)
+}
+
+func (e *csvExport) write(result *gorc.SearchResult) error {
+	record, err := exportRecord(result.RawValue, e.columns, e.redact)
+	if err != nil {
+		return err
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) flush() error {
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	flushResponse(e.ctx)
+	return nil
+}
+
+// CSV has nowhere to report an error, so a failed export is simply cut
+// short.
+func (e *csvExport) fail(ctx *web.Context, err error) {}
+
+// Exports search results as newline delimited JSON, one search result (path,
+// score and value) per line. An upstream error partway through is reported as
+// a final line holding the error envelope.
+func exportNDJSON(ctx *web.Context, collection string) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+
+	req := admitSearch(ctx, collection)
+	if req == nil {
+		return
+	}
+
+	export(ctx, req, &ndjsonExport{fields: req.fields, redact: req.policy.redact})
+}
+
+type ndjsonExport struct {
+	ctx    *web.Context
+	w      *bufio.Writer
+	fields []fieldPath
+	redact []fieldPath
+}
+
+func (e *ndjsonExport) begin(ctx *web.Context) {
+	ctx.SetHeader("Content-Type", "application/x-ndjson", true)
+	ctx.WriteHeader(200)
+
+	e.ctx = ctx
+	e.w = bufio.NewWriter(ctx.ResponseWriter)
+}
+
+func (e *ndjsonExport) write(result *gorc.SearchResult) error {
+	value, err := shapeValue(result.RawValue, e.fields, e.redact)
+	if err != nil {
+		return err
+	}
+	shaped := *result
+	shaped.RawValue = value
+	return json.NewEncoder(e.w).Encode(&shaped)
+}
+
+func (e *ndjsonExport) flush() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	flushResponse(e.ctx)
+	return nil
+}
+
+func (e *ndjsonExport) fail(ctx *web.Context, err error) {
+	apiErr := upstreamError(err)
+	apiErr.RequestID = requestID(ctx)
+	json.NewEncoder(e.w).Encode(&errorEnvelope{Error: apiErr})
+}
+
+// Flattens a result's value into a row of CSV cells, one per column. Redacted
+// paths are stripped first, so they come out empty. Strings a spreadsheet
+// would run as formulas are escaped.
+func exportRecord(raw json.RawMessage, columns, redact []fieldPath) ([]string, error) {
+	shaped, err := shapeValue(raw, nil, redact)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(shaped))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		found := lookup(value, column)
+		if record[i], err = cell(found); err != nil {
+			return nil, err
+		}
+		if _, ok := found.(string); ok {
+			record[i] = escapeFormula(record[i])
+		}
+	}
+	return record, nil
+}
+
+// Returns the value at a path, or nil if there is none. Paths that cross an
+// array return an array of the values found in its elements.
+func lookup(value interface{}, path fieldPath) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return lookup(v[path[0]], path[1:])
+	case []interface{}:
+		found := []interface{}{}
+		for _, element := range v {
+			if child := lookup(element, path); child != nil {
+				found = append(found, child)
+			}
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		return found
+	}
+	return nil
+}
+
+// Formats a value as a CSV cell. Strings, numbers and booleans are written as
+// they are, missing values and nulls as an empty cell, and objects and arrays
+// as JSON.
+func cell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Prefixes a string that a spreadsheet would take for a formula with a quote,
+// so that opening an export can't run anything.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
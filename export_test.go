@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/orchestrate-io/gorc"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const exportConfig = `{
+	"collections": {
+		"items": {"max_limit": 2, "max_export_rows": 5, "redact": ["secret"]}
+	}
+}`
+
+func seedItems(p *testProxy, n int) {
+	for i := 0; i < n; i++ {
+		p.orchestrate.Put("items", fmt.Sprintf("i%02d", i), map[string]interface{}{
+			"n":      i,
+			"name":   fmt.Sprintf("item, %d", i),
+			"tags":   []string{"a", "b"},
+			"owner":  map[string]interface{}{"name": "Ada", "admin": i == 0},
+			"secret": "hunter2",
+		})
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	seedItems(p, 4)
+
+	w := p.get("/items.csv?columns=n,name,owner.name,owner.admin,tags,secret,missing", nil)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("expected a CSV response, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"n", "name", "owner.name", "owner.admin", "tags", "secret", "missing"},
+		{"0", "item, 0", "Ada", "true", `["a","b"]`, "", ""},
+		{"1", "item, 1", "Ada", "false", `["a","b"]`, "", ""},
+		{"2", "item, 2", "Ada", "false", `["a","b"]`, "", ""},
+		{"3", "item, 3", "Ada", "false", `["a","b"]`, "", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %q, got %q", expected, records)
+	}
+	if reason := w.Result().Trailer.Get("X-Export-Truncated"); reason != "" {
+		t.Errorf("expected a complete export, got %q", reason)
+	}
+}
+
+func TestExportCSVFormulas(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	p.orchestrate.Put("items", "formula", map[string]interface{}{
+		"name": "=HYPERLINK(\"http://example.com\")", "tag": "@SUM(A1)", "n": -1, "note": "a=b",
+	})
+
+	records, err := csv.NewReader(p.get("/items.csv?columns=name,tag,n,note,%2Bx", nil).Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"name", "tag", "n", "note", "'+x"},
+		{`'=HYPERLINK("http://example.com")`, "'@SUM(A1)", "-1", "a=b", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %q, got %q", expected, records)
+	}
+}
+
+func TestExportCSVRowCap(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	seedItems(p, 9)
+
+	w := p.get("/items.csv?columns=n", nil)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 || records[5][0] != "4" {
+		t.Errorf("expected a header and 5 rows, got %q", records)
+	}
+	if reason := w.Result().Trailer.Get("X-Export-Truncated"); reason != "max_export_rows" {
+		t.Errorf("expected the export to report the row cap, got %q", reason)
+	}
+	if n := p.orchestrate.Requests(); n != 3 {
+		t.Errorf("expected the walk to stop after 3 pages, made %d requests", n)
+	}
+}
+
+func TestExportCSVOffsetCap(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {"items": {"max_limit": 2, "max_offset": 2}}}`)
+	defer p.Close()
+	seedItems(p, 9)
+
+	w := p.get("/items.csv?columns=n", nil)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || records[4][0] != "3" {
+		t.Errorf("expected a header and the 4 rows up to max_offset, got %q", records)
+	}
+	if reason := w.Result().Trailer.Get("X-Export-Truncated"); reason != "max_offset" {
+		t.Errorf("expected the export to report max_offset, got %q", reason)
+	}
+	if n := p.orchestrate.Requests(); n != 2 {
+		t.Errorf("expected the walk to stop after 2 pages, made %d requests", n)
+	}
+}
+
+func TestExportCSVDisconnect(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	seedItems(p, 9)
+
+	// The client goes away while the second page is being fetched, which
+	// cancels the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("offset") == "0" {
+			return false
+		}
+		cancel()
+		<-r.Context().Done()
+		return true
+	}
+	req, _ := http.NewRequest("GET", "/items.csv?columns=n", nil)
+	records, _ := csv.NewReader(p.do(req.WithContext(ctx)).Body).ReadAll()
+
+	if len(records) != 3 || p.orchestrate.Requests() != 2 {
+		t.Errorf("expected the export to stop after the first page, got %q from %d requests", records, p.orchestrate.Requests())
+	}
+
+	// A client that is already gone costs no calls at all.
+	w := p.do(req.WithContext(ctx))
+	if w.Body.Len() != 0 || p.orchestrate.Requests() != 2 {
+		t.Errorf("expected nothing to be fetched, got %q from %d requests", w.Body.String(), p.orchestrate.Requests())
+	}
+}
+
+func TestExportCSVErrors(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+
+	for _, test := range []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/items.csv", 400, "invalid_columns"},
+		{"/items.csv?columns=a..b", 400, "invalid_columns"},
+		{"/missing.csv?columns=a", 404, "collection_not_found"},
+	} {
+		w := p.get(test.path, nil)
+		if e := decodeError(t, w); w.Code != test.status || e.Code != test.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", test.path, test.status, test.code, w.Code, e)
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	seedItems(p, 3)
+
+	w := p.get("/items.ndjson?fields=name,secret", nil)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON response, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	for i, line := range lines {
+		var result gorc.SearchResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Path.Key != fmt.Sprintf("i%02d", i) || string(result.RawValue) != fmt.Sprintf(`{"name":"item, %d"}`, i) {
+			t.Errorf("unexpected line %d: %s", i, line)
+		}
+	}
+}
+
+func TestExportNDJSONUpstreamFailure(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	seedItems(p, 4)
+
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("offset") == "2" {
+			w.WriteHeader(503)
+			return true
+		}
+		return false
+	}
+
+	w := p.get("/items.ndjson", nil)
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if w.Code != 200 || len(lines) != 3 {
+		t.Fatalf("expected 2 results and an error line, got %d %q", w.Code, lines)
+	}
+
+	var envelope errorEnvelope
+	if err := json.Unmarshal([]byte(lines[2]), &envelope); err != nil || envelope.Error == nil {
+		t.Fatalf("expected a final error line, got %q (%v)", lines[2], err)
+	}
+	if envelope.Error.Status != 502 || envelope.Error.Code != "upstream_error" || envelope.Error.RequestID == "" {
+		t.Errorf("unexpected error line %+v", envelope.Error)
+	}
+	if reason := w.Result().Trailer.Get("X-Export-Truncated"); reason != "upstream_error" {
+		t.Errorf("expected the export to report the failure, got %q", reason)
+	}
+}
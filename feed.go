@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How a collection is presented in its OpenSearch description and Atom feeds.
+type feedConfig struct {
+	// A short name for the collection's search engine, at most 16
+	// characters. Defaults to the collection name.
+	Name string `json:"name"`
+
+	// A sentence describing the collection's search engine.
+	Description string `json:"description"`
+
+	// Dot separated paths into each result's value giving the Atom entry's
+	// title, link, summary and update time. Entries are titled with the
+	// result's key when the title is missing. Update times may be RFC 3339
+	// strings or milliseconds since the epoch.
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	Summary string `json:"summary"`
+	Updated string `json:"updated"`
+
+	title, link, summary, updated fieldPath
+}
+
+// The longest ShortName the OpenSearch specification allows.
+const maxOpenSearchName = 16
+
+// Fills in defaults for unset fields and validates the configuration.
+func (f *feedConfig) init(collection string) error {
+	if f.Name == "" {
+		f.Name = collection
+	}
+	if name := []rune(f.Name); len(name) > maxOpenSearchName {
+		f.Name = string(name[:maxOpenSearchName])
+	}
+	if f.Description == "" {
+		f.Description = fmt.Sprintf("Search %s.", collection)
+	}
+
+	for _, path := range []struct {
+		name  string
+		value string
+		path  *fieldPath
+	}{
+		{"title", f.Title, &f.title},
+		{"link", f.Link, &f.link},
+		{"summary", f.Summary, &f.summary},
+		{"updated", f.Updated, &f.updated},
+	} {
+		if path.value == "" {
+			continue
+		}
+		if _, err := parseFields(path.value); err != nil || strings.Contains(path.value, ",") {
+			return fmt.Errorf("invalid %s path %q", path.name, path.value)
+		}
+		*path.path = strings.Split(path.value, ".")
+	}
+	return nil
+}
+
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	URLs          []openSearchURL `xml:"Url"`
+	InputEncoding string          `xml:"InputEncoding"`
+}
+
+type openSearchURL struct {
+	Type        string `xml:"type,attr"`
+	Rel         string `xml:"rel,attr,omitempty"`
+	IndexOffset string `xml:"indexOffset,attr,omitempty"`
+	Template    string `xml:"template,attr"`
+}
+
+// Serves the OpenSearch description of a collection, so that browsers can add
+// it as a search engine. Only collections the anonymous tier can search
+// without a signature are described.
+func openSearch(ctx *web.Context, collection string) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+
+	policy := conf.collection(collection)
+	if policy == nil || policy.SignedOnly || !conf.Tiers[anonymousTier].allows(collection) {
+		writeError(ctx, errCollectionNotFound())
+		return
+	}
+
+	base := publicBase(ctx.Request) + "/" + url.PathEscape(collection)
+	doc := &openSearchDescription{
+		ShortName:   policy.Feed.Name,
+		Description: policy.Feed.Description,
+		URLs: []openSearchURL{
+			{Type: "application/atom+xml", Template: base + ".atom?query={searchTerms}"},
+			{Type: "application/json", IndexOffset: "0", Template: base + "?query={searchTerms}&limit={count?}&offset={startIndex?}"},
+			{Type: "application/opensearchdescription+xml", Rel: "self", Template: base + "/opensearch.xml"},
+		},
+		InputEncoding: "UTF-8",
+	}
+
+	writeXML(ctx, "application/opensearchdescription+xml", policy, doc)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+
+	TotalResults int `xml:"http://a9.com/-/spec/opensearch/1.1/ totalResults"`
+	StartIndex   int `xml:"http://a9.com/-/spec/opensearch/1.1/ startIndex"`
+	ItemsPerPage int `xml:"http://a9.com/-/spec/opensearch/1.1/ itemsPerPage"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link,omitempty"`
+	Summary string     `xml:"summary,omitempty"`
+}
+
+// Serves a page of search results as an Atom feed, so that a saved query can
+// be followed in a feed reader.
+func searchFeed(ctx *web.Context, collection string) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+
+	req := admitSearch(ctx, collection)
+	if req == nil {
+		return
+	}
+
+	// Entries are built from the whole of each value.
+	req.fields = nil
+	body, err := fetchResults(ctx, req)
+	if err != nil {
+		writeUpstreamError(ctx, err)
+		return
+	}
+	results := new(gorc.SearchResults)
+	if err := json.Unmarshal(body, results); err != nil {
+		writeUpstreamError(ctx, err)
+		return
+	}
+	accessEntryOf(ctx).results(int(results.Count), int(results.TotalCount))
+
+	writeXML(ctx, "application/atom+xml", req.policy, newAtomFeed(ctx, req, results, time.Now()))
+}
+
+// Builds the Atom feed of a page of results. Entries without an update time
+// are stamped with now.
+func newAtomFeed(ctx *web.Context, req *searchRequest, results *gorc.SearchResults, now time.Time) *atomFeed {
+	base := publicBase(ctx.Request)
+	self := base + ctx.Request.URL.RequestURI()
+	feed := &atomFeed{
+		ID:    self,
+		Title: fmt.Sprintf("%s: %s", req.policy.Feed.Name, req.query),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "first", Type: "application/atom+xml", Href: pageURL(ctx, req, 0)},
+		},
+		TotalResults: int(results.TotalCount),
+		StartIndex:   req.offset,
+		ItemsPerPage: req.limit,
+	}
+
+	for _, link := range []struct{ rel, href string }{{"next", results.Next}, {"previous", results.Prev}} {
+		if offset, ok := linkOffset(link.href); ok && link.href != "" && offset <= req.policy.MaxOffset {
+			feed.Links = append(feed.Links, atomLink{Rel: link.rel, Type: "application/atom+xml", Href: pageURL(ctx, req, offset)})
+		}
+	}
+
+	var latest time.Time
+	for _, result := range results.Results {
+		entry, updated := newAtomEntry(base, req, &result, now)
+		if updated.After(latest) {
+			latest = updated
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if latest.IsZero() {
+		latest = now
+	}
+	feed.Updated = latest.UTC().Format(time.RFC3339)
+
+	return feed
+}
+
+// Builds the Atom entry of a result, returning it along with its update time.
+func newAtomEntry(base string, req *searchRequest, result *gorc.SearchResult, now time.Time) (atomEntry, time.Time) {
+	feed := req.policy.Feed
+	decoder := json.NewDecoder(bytes.NewReader(result.RawValue))
+	decoder.UseNumber()
+	var value interface{}
+	decoder.Decode(&value)
+
+	text := func(path fieldPath) string {
+		if path == nil {
+			return ""
+		}
+		s, _ := cell(lookup(value, path))
+		return s
+	}
+
+	entry := atomEntry{
+		ID:      base + "/" + url.PathEscape(req.collection) + "#" + url.QueryEscape(result.Path.Key),
+		Title:   text(feed.title),
+		Summary: text(feed.summary),
+	}
+	if entry.Title == "" {
+		entry.Title = result.Path.Key
+	}
+	if link := text(feed.link); link != "" {
+		if u, err := url.Parse(link); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			entry.Links = []atomLink{{Rel: "alternate", Href: link}}
+		}
+	}
+
+	updated := now
+	if feed.updated != nil {
+		if t, ok := parseUpdated(lookup(value, feed.updated)); ok {
+			updated = t
+		}
+	}
+	entry.Updated = updated.UTC().Format(time.RFC3339)
+
+	return entry, updated
+}
+
+// Parses an update time given as an RFC 3339 string or as milliseconds since
+// the epoch.
+func parseUpdated(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	case json.Number:
+		ms, err := v.Int64()
+		return time.Unix(0, ms*int64(time.Millisecond)), err == nil
+	}
+	return time.Time{}, false
+}
+
+// Writes an XML document, answering conditional requests as searches do.
+func writeXML(ctx *web.Context, contentType string, policy *collectionPolicy, doc interface{}) {
+	buf := bytes.NewBufferString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(doc); err != nil {
+		writeError(ctx, newAPIError(500, "internal_error", "The response could not be encoded."))
+		return
+	}
+	buf.WriteString("\n")
+
+	if writeConditional(ctx, policy, buf.Bytes()) {
+		return
+	}
+	ctx.SetHeader("Content-Type", contentType+"; charset=utf-8", true)
+	ctx.WriteHeader(200)
+	ctx.Write(buf.Bytes())
+}
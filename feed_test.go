@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const feedTestConfig = `{
+	"collections": {
+		"posts": {
+			"max_limit": 2,
+			"redact": ["author.email"],
+			"feed": {"name": "Blog", "title": "title", "link": "url", "summary": "author", "updated": "published"}
+		},
+		"private": {"signed_only": true}
+	},
+	"signing_secret": "secret"
+}`
+
+func seedPosts(p *testProxy) {
+	p.orchestrate.Put("posts", "a", map[string]interface{}{
+		"title":     "First",
+		"url":       "https://blog.example.com/first",
+		"published": "2014-05-01T10:00:00Z",
+		"author":    map[string]interface{}{"name": "Ada", "email": "ada@example.com"},
+	})
+	p.orchestrate.Put("posts", "b", map[string]interface{}{
+		"title":     "Second",
+		"url":       "javascript:alert(1)",
+		"published": 1401616800000,
+	})
+	p.orchestrate.Put("posts", "c", map[string]interface{}{})
+}
+
+func TestOpenSearch(t *testing.T) {
+	p := newTestProxy(t, feedTestConfig)
+	defer p.Close()
+
+	req, _ := http.NewRequest("GET", "/posts/opensearch.xml", nil)
+	req.Host = "search.example.com"
+	w := p.do(req)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/opensearchdescription+xml; charset=utf-8" {
+		t.Fatalf("expected a description, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var doc openSearchDescription
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ShortName != "Blog" || len(doc.URLs) != 3 || doc.URLs[0].Template != "http://search.example.com/posts.atom?query={searchTerms}" {
+		t.Errorf("unexpected description %+v", doc)
+	}
+
+	for _, path := range []string{"/private/opensearch.xml", "/missing/opensearch.xml"} {
+		if w := p.get(path, nil); w.Code != 404 {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestFeedConfigName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":                       "posts",
+		"Blog":                   "Blog",
+		"The company blog posts": "The company blog",
+		"Ünïcödé blög pösts":     "Ünïcödé blög pös",
+	} {
+		f := &feedConfig{Name: name}
+		if err := f.init("posts"); err != nil {
+			t.Fatal(err)
+		}
+		if f.Name != expected {
+			t.Errorf("%q: expected the name %q, got %q", name, expected, f.Name)
+		}
+	}
+}
+
+func TestSearchFeed(t *testing.T) {
+	p := newTestProxy(t, feedTestConfig)
+	defer p.Close()
+	seedPosts(p)
+
+	w := p.get("/posts.atom?fields=title", nil)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("expected a feed, got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.TotalResults != 3 || len(feed.Entries) != 2 || feed.Updated != "2014-06-01T10:00:00Z" {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+
+	first, second := feed.Entries[0], feed.Entries[1]
+	if first.Title != "First" || first.Updated != "2014-05-01T10:00:00Z" || len(first.Links) != 1 || first.Links[0].Href != "https://blog.example.com/first" {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	if first.Summary != `{"name":"Ada"}` {
+		t.Errorf("expected the summary to be redacted, got %q", first.Summary)
+	}
+	if second.Title != "Second" || second.Updated != "2014-06-01T10:00:00Z" || len(second.Links) != 0 {
+		t.Errorf("unexpected second entry %+v", second)
+	}
+
+	var next string
+	for _, link := range feed.Links {
+		if link.Rel == "next" {
+			next = link.Href
+		}
+	}
+	if !strings.Contains(next, "/posts.atom?cursor=") {
+		t.Fatalf("expected a next link to the feed, got %q", next)
+	}
+	var page atomFeed
+	if err := xml.Unmarshal(p.get(next[strings.Index(next, "/posts"):], nil).Body.Bytes(), &page); err != nil || len(page.Entries) != 1 || page.Entries[0].Title != "c" {
+		t.Errorf("expected the second page to hold c, titled by its key, got %+v (%v)", page.Entries, err)
+	}
+}
+
+func TestSearchFeedFormulaLikeText(t *testing.T) {
+	p := newTestProxy(t, feedTestConfig)
+	defer p.Close()
+	p.orchestrate.Put("posts", "cold", map[string]interface{}{"title": "-40 degrees in Winnipeg", "author": "=cool"})
+
+	var feed atomFeed
+	if err := xml.Unmarshal(p.get("/posts.atom", nil).Body.Bytes(), &feed); err != nil || len(feed.Entries) != 1 {
+		t.Fatalf("expected a feed of one entry, got %+v (%v)", feed, err)
+	}
+	if entry := feed.Entries[0]; entry.Title != "-40 degrees in Winnipeg" || entry.Summary != "=cool" {
+		t.Errorf("expected the text as it is, without CSV escaping, got %+v", entry)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"github.com/hoisie/web"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a readiness check waits for Orchestrate when the
+	// configuration does not say.
+	defaultReadyTimeout = 2 * time.Second
+
+	// How long a readiness result is reused when the configuration does not
+	// say.
+	defaultReadyCacheTTL = 5 * time.Second
+)
+
+// Checks whether Orchestrate is reachable with the configured key. Results
+// are reused for a while, so that frequent probes don't add load, and
+// concurrent probes share a single ping.
+type readinessCheck struct {
+	ping    func(ctx context.Context) error
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	latency time.Duration
+	err     error
+}
+
+func newReadinessCheck(ping func(ctx context.Context) error, timeout, ttl time.Duration) *readinessCheck {
+	return &readinessCheck{ping: ping, timeout: timeout, ttl: ttl}
+}
+
+// The outcome of a readiness check.
+type readiness struct {
+	checked time.Time
+	latency time.Duration
+	err     error
+}
+
+// Returns the latest result, pinging Orchestrate if it is out of date. A
+// ping that takes longer than the timeout counts as a failure, and is
+// canceled.
+func (r *readinessCheck) check(now time.Time) readiness {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.checked.IsZero() || now.Sub(r.checked) >= r.ttl {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		done := make(chan error, 1)
+		start := time.Now()
+		go func() { done <- r.ping(ctx) }()
+
+		select {
+		case r.err = <-done:
+		case <-ctx.Done():
+			r.err = errUpstreamTimeout()
+		}
+		r.checked, r.latency = now, time.Since(start)
+	}
+
+	return readiness{checked: r.checked, latency: r.latency, err: r.err}
+}
+
+// Reports that the process is alive. It does not depend on Orchestrate.
+func health(ctx *web.Context) {
+	response := map[string]string{"status": "ok"}
+	if upstreamBreaker != nil {
+		state, _ := upstreamBreaker.status(time.Now())
+		response["circuit_breaker"] = state.String()
+	}
+	writeJSON(ctx, 200, response)
+}
+
+type readyResponse struct {
+	Status         string            `json:"status"`
+	Orchestrate    upstreamReadiness `json:"orchestrate"`
+	CircuitBreaker *breakerStatus    `json:"circuit_breaker,omitempty"`
+}
+
+type breakerStatus struct {
+	State string `json:"state"`
+	Since string `json:"since"`
+}
+
+type upstreamReadiness struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	CheckedAt string  `json:"checked_at"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Reports whether the proxy can serve searches: a 200 if Orchestrate answers
+// a ping with the configured key, a 503 if not.
+func ready(ctx *web.Context) {
+	result := readinessChecker.check(time.Now())
+	response := &readyResponse{
+		Status: "ready",
+		Orchestrate: upstreamReadiness{
+			Status:    "ok",
+			CheckedAt: result.checked.UTC().Format(time.RFC3339),
+			LatencyMS: milliseconds(result.latency),
+		},
+	}
+
+	if upstreamBreaker != nil {
+		state, changed := upstreamBreaker.status(time.Now())
+		response.CircuitBreaker = &breakerStatus{State: state.String(), Since: changed.UTC().Format(time.RFC3339)}
+	}
+
+	status := 200
+	if result.err != nil {
+		status = 503
+		response.Status = "unavailable"
+		response.Orchestrate.Status = "error"
+		response.Orchestrate.Error = upstreamError(result.err).Code
+	}
+	writeJSON(ctx, status, response)
+}
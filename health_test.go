@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	pings := 0
+	fail := errors.New("unreachable")
+	var result error
+	r := newReadinessCheck(func(context.Context) error { pings++; return result }, time.Second, 5*time.Second)
+
+	now := time.Now()
+	if got := r.check(now); got.err != nil || pings != 1 {
+		t.Fatalf("expected a successful ping, got %v after %d pings", got.err, pings)
+	}
+
+	result = fail
+	if got := r.check(now.Add(4 * time.Second)); got.err != nil || pings != 1 {
+		t.Errorf("expected the cached result, got %v after %d pings", got.err, pings)
+	}
+	if got := r.check(now.Add(5 * time.Second)); got.err != fail || pings != 2 {
+		t.Errorf("expected a fresh failure, got %v after %d pings", got.err, pings)
+	}
+}
+
+func TestReadinessCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	r := newReadinessCheck(func(context.Context) error { <-release; return nil }, 10*time.Millisecond, time.Second)
+
+	if got := r.check(time.Now()); upstreamError(got.err).Code != "upstream_timeout" {
+		t.Errorf("expected a slow ping to time out, got %v", got.err)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {"people": {}}}`)
+	defer p.Close()
+
+	if w := p.get("/_health", nil); w.Code != 200 {
+		t.Errorf("expected /_health to be 200, got %d", w.Code)
+	}
+
+	w := p.get("/_ready", nil)
+	var response readyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != 200 || response.Status != "ready" {
+		t.Errorf("expected ready, got %d %s", w.Code, w.Body.String())
+	}
+
+	p.orchestrate.AuthToken = "other"
+	readinessChecker = newReadinessCheck(func(ctx context.Context) error { return c.PingContext(ctx) }, time.Second, time.Second)
+	w = p.get("/_ready", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != 503 || response.Orchestrate.Error != "upstream_unauthorized" {
+		t.Errorf("expected a rejected key to be unready, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {"people": {}}}`)
+	defer p.Close()
+
+	for _, path := range []string{"/_status", "/_ready.csv", "/_health/"} {
+		w := p.get(path, nil)
+		if e := decodeError(t, w); w.Code != 404 || e.Code != "not_found" {
+			t.Errorf("%s: expected the system namespace to 404, got %d %+v", path, w.Code, e)
+		}
+	}
+
+	for _, name := range []string{"_health", "_private", "metrics"} {
+		if _, err := parseConfig([]byte(`{"collections": {"` + name + `": {}}}`)); err == nil {
+			t.Errorf("expected the collection name %q to be reserved", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/hoisie/web"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wraps a handler taking a collection so that its requests are counted and
+// logged. Only configured collections are used as metric labels, so that
+// clients can't create series at will.
+func instrument(route string, handler func(*web.Context, string)) func(*web.Context, string) {
+	return func(ctx *web.Context, collection string) {
+		w := trackRequest(ctx, route)
+		defer untrackRequest(ctx, w)
+
+		handler(ctx, collection)
+
+		collection = strings.TrimSuffix(collection, "/")
+		if conf.collection(collection) == nil {
+			collection = ""
+		}
+		requestsTotal.inc(collection, route, strconv.Itoa(w.status))
+		logAccess(ctx, route, w.status, w.entry)
+	}
+}
+
+// Wraps a handler that does not take a collection so that its requests are
+// counted and logged.
+func instrumentRoute(route string, handler func(*web.Context)) func(*web.Context) {
+	return func(ctx *web.Context) {
+		w := trackRequest(ctx, route)
+		defer untrackRequest(ctx, w)
+
+		handler(ctx)
+
+		requestsTotal.inc("", route, strconv.Itoa(w.status))
+		logAccess(ctx, route, w.status, w.entry)
+	}
+}
+
+// Counts a request as in flight and starts recording its status and access
+// log entry.
+func trackRequest(ctx *web.Context, route string) *trackedResponse {
+	requestsInFlight.add(1)
+	w := &trackedResponse{ResponseWriter: ctx.ResponseWriter, status: 200, entry: &accessEntry{start: time.Now()}}
+	ctx.ResponseWriter = w
+	inflight.add(ctx, route, w)
+	return w
+}
+
+// Counts a request as finished. A handler that panicked is logged, and the
+// panic passed on to the web server, which responds with a 500.
+func untrackRequest(ctx *web.Context, w *trackedResponse) {
+	inflight.remove(w)
+	requestsInFlight.add(-1)
+
+	if err := recover(); err != nil {
+		logError(ctx, "handler crashed: %v\n%s", err, debug.Stack())
+		panic(err)
+	}
+}
+
+// A response writer that remembers the status it sent, and carries the
+// request's access log entry and JSONP callback.
+type trackedResponse struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	entry       *accessEntry
+	callback    string
+}
+
+func (w *trackedResponse) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackedResponse) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
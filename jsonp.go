@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"github.com/hoisie/web"
+	"regexp"
+	"strings"
+)
+
+// The longest JSONP callback name accepted.
+const maxCallbackLength = 128
+
+// JSONP callbacks must be a JavaScript identifier or a dotted path of them,
+// e.g. widgets.search.render, so that they can't inject script.
+var callbackPattern = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$]*(\.[A-Za-z_$][0-9A-Za-z_$]*)*$`)
+
+func validCallback(name string) bool {
+	return len(name) <= maxCallbackLength && callbackPattern.MatchString(name)
+}
+
+// Checks the callback parameter of a search, if any. Callbacks are refused
+// for collections that have not opted in to JSONP. An accepted callback is
+// recorded on the response, so that everything written to it, errors
+// included, is wrapped in the callback.
+func checkCallback(ctx *web.Context, collection string) *apiError {
+	name := ctx.Params["callback"]
+	if name == "" {
+		return nil
+	}
+
+	policy := conf.collection(strings.TrimSuffix(collection, "/"))
+	switch {
+	case policy == nil:
+		return nil
+	case !policy.JSONP:
+		return newAPIError(400, "jsonp_not_allowed", "This collection does not support JSONP callbacks.")
+	case !validCallback(name):
+		return newAPIError(400, "invalid_callback", "The callback must be a JavaScript function name.")
+	}
+
+	if w, ok := ctx.ResponseWriter.(*trackedResponse); ok {
+		w.callback = name
+	}
+	return nil
+}
+
+// Returns the JSONP callback a response should be wrapped in, or "" if the
+// response is plain JSON.
+func jsonpCallback(ctx *web.Context) string {
+	if w, ok := ctx.ResponseWriter.(*trackedResponse); ok {
+		return w.callback
+	}
+	return ""
+}
+
+// Writes a JSON body wrapped in a call to callback. Script tags can't see the
+// HTTP status, so the response is always a 200; error bodies carry their
+// status in the error envelope instead.
+func writeJSONP(ctx *web.Context, callback string, body []byte) {
+	ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8", true)
+	ctx.SetHeader("X-Content-Type-Options", "nosniff", true)
+	ctx.WriteHeader(200)
+
+	// The leading comment stops the response being sniffed as anything
+	// other than script.
+	ctx.Write([]byte("/**/" + callback + "("))
+	ctx.Write(bytes.TrimSpace(body))
+	ctx.Write([]byte(");\n"))
+}
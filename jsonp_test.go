@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const jsonpConfig = `{
+	"collections": {
+		"people": {"jsonp": true, "max_offset": 10},
+		"plain": {}
+	}
+}`
+
+func TestValidCallback(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"render":                 true,
+		"widgets.search.render":  true,
+		"$_jq123":                true,
+		"":                       false,
+		"1render":                false,
+		"render()":               false,
+		"a.b.":                   false,
+		"alert(1);x":             false,
+		"a[0]":                   false,
+		strings.Repeat("a", 129): false,
+	} {
+		if validCallback(name) != valid {
+			t.Errorf("validCallback(%q): expected %t", name, valid)
+		}
+	}
+}
+
+// Returns the JSON argument a JSONP response passes to its callback.
+func jsonpArgument(t *testing.T, body, callback string) json.RawMessage {
+	prefix, suffix := "/**/"+callback+"(", ");\n"
+	if !strings.HasPrefix(body, prefix) || !strings.HasSuffix(body, suffix) {
+		t.Fatalf("expected a call to %s, got %q", callback, body)
+	}
+	return json.RawMessage(strings.TrimSuffix(strings.TrimPrefix(body, prefix), suffix))
+}
+
+func TestSearchJSONP(t *testing.T) {
+	p := newTestProxy(t, jsonpConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	w := p.get("/people?callback=widgets.render&query=name:Ada", nil)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/javascript; charset=utf-8" {
+		t.Fatalf("expected a script, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var results struct{ Count int }
+	if err := json.Unmarshal(jsonpArgument(t, w.Body.String(), "widgets.render"), &results); err != nil || results.Count != 1 {
+		t.Errorf("expected one result, got %+v (%v)", results, err)
+	}
+
+	w = p.get("/people?callback=render&offset=11", nil)
+	var envelope errorEnvelope
+	if err := json.Unmarshal(jsonpArgument(t, w.Body.String(), "render"), &envelope); err != nil || envelope.Error == nil {
+		t.Fatalf("expected an error envelope, got %q (%v)", w.Body.String(), err)
+	}
+	if w.Code != 200 || envelope.Error.Status != 400 || envelope.Error.Code != "offset_too_large" {
+		t.Errorf("expected a 400 reported to the callback, got %d %+v", w.Code, envelope.Error)
+	}
+}
+
+func TestSearchJSONPRefused(t *testing.T) {
+	p := newTestProxy(t, jsonpConfig)
+	defer p.Close()
+
+	for _, test := range []struct {
+		path string
+		code string
+	}{
+		{"/plain?callback=render", "jsonp_not_allowed"},
+		{"/people?callback=alert(1)", "invalid_callback"},
+	} {
+		w := p.get(test.path, nil)
+		if e := decodeError(t, w); w.Code != 400 || e.Code != test.code {
+			t.Errorf("%s: expected 400 %s, got %d %+v", test.path, test.code, w.Code, e)
+		}
+	}
+}
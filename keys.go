@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The tier of requests that carry no API key.
+	anonymousTier = "anonymous"
+
+	// The header an API key may be sent in. It may also be sent as the key
+	// parameter.
+	apiKeyHeader = "X-Api-Key"
+
+	// How often keys are reloaded from the keys collection when the
+	// configuration does not say.
+	defaultKeysRefresh = time.Minute
+
+	// The requests per minute and per day allowed to each anonymous caller
+	// when the anonymous tier is not configured.
+	defaultAnonymousRate  = 60
+	defaultAnonymousQuota = 1000
+)
+
+// An access tier. Every request is served under a tier: the tier of its API
+// key, or the anonymous tier.
+type tier struct {
+	// The collections visible in the tier. Omitting the list, or including
+	// "*", makes every public collection visible; an empty list makes none
+	// visible.
+	Collections []string `json:"collections"`
+
+	// The largest page size the tier may ask for. Zero means the collection
+	// policy alone decides.
+	MaxLimit int `json:"max_limit"`
+
+	// The rate limit applied to each caller in the tier, in place of the
+	// collection's rate limit.
+	RateLimit *rateLimit `json:"rate_limit"`
+
+	// The number of requests each caller in the tier may make per UTC day.
+	// Zero means unlimited.
+	DailyQuota int64 `json:"daily_quota"`
+
+	// The rate limit applied when neither the tier nor the collection sets
+	// one.
+	fallbackRateLimit *rateLimit
+
+	visible map[string]bool
+}
+
+// Returns the restricted tier anonymous requests fall into when the
+// anonymous tier is not configured, along with the public collections it
+// leaves out. It sees every public collection that no other tier lists by
+// name, and is rate limited and given a daily quota.
+func defaultAnonymous(conf *config) (*tier, []string) {
+	named := map[string]bool{}
+	for _, t := range conf.Tiers {
+		if t == nil {
+			continue
+		}
+		for _, name := range t.Collections {
+			named[name] = true
+		}
+	}
+
+	t := &tier{
+		Collections:       []string{},
+		DailyQuota:        defaultAnonymousQuota,
+		fallbackRateLimit: &rateLimit{Requests: defaultAnonymousRate},
+	}
+	gated := []string{}
+	for name := range conf.Collections {
+		if named[name] {
+			gated = append(gated, name)
+		} else {
+			t.Collections = append(t.Collections, name)
+		}
+	}
+	sort.Strings(t.Collections)
+	sort.Strings(gated)
+	return t, gated
+}
+
+// An API key, as held in the configuration or the keys collection.
+type apiKey struct {
+	// The name of the key's tier.
+	Tier string `json:"tier"`
+
+	// Revoked keys are rejected.
+	Revoked bool `json:"revoked"`
+
+	// A description of the key's holder, for reference only.
+	Name string `json:"name"`
+}
+
+// Fills in defaults for unset fields and validates the tier.
+func (t *tier) init() error {
+	if t.MaxLimit < 0 {
+		return fmt.Errorf("max_limit must not be negative")
+	}
+	if t.DailyQuota < 0 {
+		return fmt.Errorf("daily_quota must not be negative")
+	}
+	if t.RateLimit != nil {
+		if err := t.RateLimit.init(); err != nil {
+			return fmt.Errorf("rate_limit: %s", err)
+		}
+	}
+	if t.fallbackRateLimit != nil {
+		if err := t.fallbackRateLimit.init(); err != nil {
+			return err
+		}
+	}
+
+	if t.Collections != nil {
+		t.visible = map[string]bool{}
+		for _, name := range t.Collections {
+			if name == "*" {
+				t.visible = nil
+				break
+			}
+			t.visible[name] = true
+		}
+	}
+	return nil
+}
+
+// Reports whether a collection is visible in the tier.
+func (t *tier) allows(collection string) bool {
+	return t.visible == nil || t.visible[collection]
+}
+
+// Applies the tier's maximum to a page size.
+func (t *tier) clamp(limit int) int {
+	if t.MaxLimit > 0 && limit > t.MaxLimit {
+		return t.MaxLimit
+	}
+	return limit
+}
+
+// The caller of a request.
+type caller struct {
+	// Identifies the caller for rate limits and quotas: the API key, or the
+	// client IP for anonymous callers.
+	id string
+
+	// The name of the caller's tier, and the tier itself.
+	tierName string
+	tier     *tier
+}
+
+// Identifies the caller of a request from its API key, or from its IP when
+// it has none. Unknown and revoked keys are rejected rather than treated as
+// anonymous, so that mistakes are noticed.
+func identify(ctx *web.Context) (*caller, *apiError) {
+	key := ctx.Request.Header.Get(apiKeyHeader)
+	if key == "" {
+		key = ctx.Params["key"]
+	}
+
+	if key == "" {
+		return &caller{
+			id:       "ip:" + clientIP(ctx.Request, *conf.ProxyHops),
+			tierName: anonymousTier,
+			tier:     conf.Tiers[anonymousTier],
+		}, nil
+	}
+
+	k := keys.lookup(key)
+	if k == nil || k.Revoked || conf.Tiers[k.Tier] == nil {
+		return nil, newAPIError(401, "invalid_key", "The API key is not valid.")
+	}
+
+	return &caller{id: "key:" + key, tierName: k.Tier, tier: conf.Tiers[k.Tier]}, nil
+}
+
+// The set of API keys. Keys from the configuration are fixed; keys from the
+// keys collection are reloaded periodically so that they can be issued and
+// revoked without a restart.
+type keyring struct {
+	mu      sync.RWMutex
+	static  map[string]*apiKey
+	dynamic map[string]*apiKey
+}
+
+func newKeyring(static map[string]*apiKey) *keyring {
+	return &keyring{static: static, dynamic: map[string]*apiKey{}}
+}
+
+// Returns the key with the given value, or nil if there is none. Keys in the
+// keys collection take precedence, so a configured key can be revoked there.
+func (k *keyring) lookup(key string) *apiKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if found := k.dynamic[key]; found != nil {
+		return found
+	}
+	return k.static[key]
+}
+
+// Replaces the keys loaded from a collection. Each item's key is an API key
+// and its value an apiKey.
+func (k *keyring) load(c *gorc.Client, collection string) error {
+	loaded := map[string]*apiKey{}
+
+	results, err := c.List(collection, maxLimit)
+	for {
+		if err != nil {
+			return err
+		}
+		for _, result := range results.Results {
+			key := new(apiKey)
+			if err := result.Value(key); err != nil {
+				logError(nil, "Ignoring API key %q: %s", result.Path.Key, err)
+				continue
+			}
+			loaded[result.Path.Key] = key
+		}
+		if !results.HasNext() {
+			break
+		}
+		results, err = c.ListGetNext(results)
+	}
+
+	k.mu.Lock()
+	k.dynamic = loaded
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Reloads the keys collection every interval, forever. Failed loads keep the
+// previously loaded keys.
+func (k *keyring) watch(c *gorc.Client, collection string, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := k.load(c, collection); err != nil {
+			logError(nil, "Failed to reload API keys: %s", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const tieredConfig = `{
+	"collections": {"people": {}, "partners": {}},
+	"tiers": {
+		"anonymous": {"collections": ["people"], "max_limit": 1, "daily_quota": 2},
+		"partner": {"collections": ["*"]}
+	},
+	"keys": {"pk_static": {"tier": "partner"}},
+	"keys_collection": "api_keys"
+}`
+
+func TestDefaultAnonymousTier(t *testing.T) {
+	p := newTestProxy(t, `{
+		"collections": {"people": {}, "partners": {}, "limited": {"rate_limit": {"requests": 2, "per": "1h"}}},
+		"tiers": {"partner": {"collections": ["partners"]}}
+	}`)
+	defer p.Close()
+	seedPeople(p)
+
+	if w := p.get("/partners", nil); w.Code != 404 {
+		t.Errorf("expected a collection a tier lists to be hidden from anonymous callers, got %d", w.Code)
+	}
+
+	w := p.get("/people", nil)
+	if w.Code != 200 || w.Header().Get("X-Quota-Limit") != strconv.Itoa(defaultAnonymousQuota) {
+		t.Errorf("expected the default daily quota, got %d %v", w.Code, w.Header())
+	}
+	for i := 1; i < defaultAnonymousRate; i++ {
+		p.get("/people", nil)
+	}
+	if w := p.get("/people", nil); w.Code != 429 {
+		t.Errorf("expected the default rate limit to apply, got %d", w.Code)
+	}
+
+	// A collection's own rate limit takes precedence.
+	p.get("/limited", nil)
+	p.get("/limited", nil)
+	if w := p.get("/limited", nil); w.Code != 429 {
+		t.Errorf("expected the collection's rate limit to apply, got %d", w.Code)
+	}
+}
+
+func TestTierVisibility(t *testing.T) {
+	conf, err := parseConfig([]byte(`{
+		"collections": {"a": {}, "b": {}},
+		"tiers": {"none": {"collections": []}, "some": {"collections": ["a"]}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if anonymous := conf.Tiers[anonymousTier]; anonymous.allows("a") || !anonymous.allows("b") {
+		t.Error("expected the default anonymous tier to see only collections no tier lists")
+	}
+	if !reflect.DeepEqual(conf.anonymousGated, []string{"a"}) {
+		t.Errorf("expected the collections left out of the default anonymous tier to be recorded, got %q", conf.anonymousGated)
+	}
+	if conf.Tiers["none"].allows("a") {
+		t.Error("expected an empty list to hide everything")
+	}
+	if !conf.Tiers["some"].allows("a") || conf.Tiers["some"].allows("b") {
+		t.Error("expected only listed collections to be visible")
+	}
+
+	if _, err := parseConfig([]byte(`{"keys": {"k": {"tier": "missing"}}}`)); err == nil {
+		t.Error("expected a key with an unknown tier to be rejected")
+	}
+	if _, err := parseConfig([]byte(`{"collections": {"keys": {}}, "keys_collection": "keys"}`)); err == nil {
+		t.Error("expected a public keys collection to be rejected")
+	}
+}
+
+func TestSearchTiers(t *testing.T) {
+	p := newTestProxy(t, tieredConfig)
+	defer p.Close()
+	seedPeople(p)
+	p.orchestrate.Put("partners", "acme", map[string]interface{}{"name": "Acme"})
+	p.orchestrate.Put("api_keys", "pk_dynamic", &apiKey{Tier: "partner"})
+	p.orchestrate.Put("api_keys", "pk_revoked", &apiKey{Tier: "partner", Revoked: true})
+	if err := keys.load(c, "api_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := p.get("/partners", nil); w.Code != 404 {
+		t.Errorf("expected partners to be hidden from anonymous callers, got %d", w.Code)
+	}
+	for _, path := range []string{"/partners?key=pk_static", "/partners?key=pk_dynamic"} {
+		if w := p.get(path, nil); w.Code != 200 {
+			t.Errorf("%s: expected 200, got %d", path, w.Code)
+		}
+	}
+	if w := p.get("/partners", http.Header{apiKeyHeader: {"pk_static"}}); w.Code != 200 {
+		t.Errorf("expected the key header to be accepted, got %d", w.Code)
+	}
+
+	for _, key := range []string{"pk_revoked", "pk_unknown"} {
+		w := p.get("/people?key="+key, nil)
+		if w.Code != 401 {
+			t.Errorf("%s: expected 401, got %d", key, w.Code)
+		} else if e := decodeError(t, w); e.Code != "invalid_key" {
+			t.Errorf("%s: unexpected error %+v", key, e)
+		}
+	}
+}
+
+func TestSearchTierLimits(t *testing.T) {
+	p := newTestProxy(t, tieredConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	w := p.get("/people?limit=10", nil)
+	if w.Code != 200 || w.Header().Get("X-Quota-Remaining") != "1" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if count := decodeResults(t, w).Count; count != 1 {
+		t.Errorf("expected the anonymous tier to be clamped to 1 result, got %d", count)
+	}
+
+	p.get("/people", nil)
+	w = p.get("/people", nil)
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the quota to be exhausted, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "quota_exceeded" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	if w := p.get("/people?key=pk_static", nil); w.Code != 200 {
+		t.Errorf("expected keyed callers to have their own quota, got %d", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"net/http"
+	"net/url"
+	"orchestrate-heroku-search/signedurl"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns the absolute URL of the proxy's root as clients see it, without a
+// trailing slash.
+func publicBase(req *http.Request) string {
+	if conf.PublicURL != "" {
+		return strings.TrimSuffix(conf.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if *conf.ProxyHops > 0 {
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + req.Host
+}
+
+// Returns the absolute proxy URL of the page of a search starting at offset.
+// The search is carried by a cursor in place of the query, limit and offset
+// parameters; the request's other parameters, such as its key and fields, are
+// kept. Links from a signed request are signed again with the same expiry, so
+// that clients can page through a signed search.
+func pageURL(ctx *web.Context, req *searchRequest, offset int) string {
+	params := ctx.Request.URL.Query()
+	cur := &cursor{
+		Collection: req.collection,
+		Query:      req.query,
+		Limit:      req.limit,
+		Offset:     offset,
+	}
+	if req.signed {
+		cur.Signed = true
+		cur.Expires, _ = strconv.ParseInt(params.Get(signedurl.ExpiresParam), 10, 64)
+	}
+	params.Del(signedurl.SignatureParam)
+	params.Del(signedurl.ExpiresParam)
+
+	params.Del("query")
+	params.Del("limit")
+	params.Del("offset")
+	params.Set("cursor", encodeCursor(conf.cursorKey, cur))
+
+	link := publicBase(ctx.Request) + ctx.Request.URL.Path + "?" + params.Encode()
+	if !req.signed {
+		return link
+	}
+
+	signed, err := signedurl.Sign([]byte(conf.SigningSecret), link, time.Unix(cur.Expires, 0))
+	if err != nil {
+		logError(ctx, "failed to sign link: %s", err)
+		return link
+	}
+	return signed
+}
+
+// Returns the offset of the page an Orchestrate paging link points to. Links
+// to the first page may leave the offset out.
+func linkOffset(link string) (int, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, false
+	}
+	value := u.Query().Get("offset")
+	if value == "" {
+		return 0, true
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+// Replaces the Orchestrate paging links in an encoded page of results with
+// proxy URLs, and sets a Link header with the next, prev and first pages.
+// Links that can not be understood, or that go beyond the collection's
+// maximum offset, are dropped rather than leaked.
+func rewriteLinks(ctx *web.Context, req *searchRequest, body []byte) ([]byte, error) {
+	results := new(gorc.SearchResults)
+	if err := json.Unmarshal(body, results); err != nil {
+		return nil, err
+	}
+
+	var links []string
+	rewrite := func(link, rel string) string {
+		if link == "" {
+			return ""
+		}
+		offset, ok := linkOffset(link)
+		if !ok || offset > req.policy.MaxOffset {
+			return ""
+		}
+		proxied := pageURL(ctx, req, offset)
+		links = append(links, "<"+proxied+`>; rel="`+rel+`"`)
+		return proxied
+	}
+
+	accessEntryOf(ctx).results(int(results.Count), int(results.TotalCount))
+
+	results.Next = rewrite(results.Next, "next")
+	results.Prev = rewrite(results.Prev, "prev")
+	links = append(links, "<"+pageURL(ctx, req, 0)+`>; rel="first"`)
+	ctx.SetHeader("Link", strings.Join(links, ", "), true)
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(results); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"orchestrate-heroku-search/signedurl"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchLinks(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	req, _ := http.NewRequest("GET", "/people?key=&fields=name&limit=1&offset=1", nil)
+	req.Host = "search.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := p.do(req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	results := decodeResults(t, w)
+	next, _ := url.Parse(results.Next)
+	prev, _ := url.Parse(results.Prev)
+	if next == nil || next.Scheme != "https" || next.Host != "search.example.com" || next.Path != "/people" {
+		t.Fatalf("expected an absolute proxy link, got %q", results.Next)
+	}
+	if cur, err := decodeCursor(conf.cursorKey, next.Query().Get("cursor")); err != nil || cur.Offset != 2 || cur.Limit != 1 {
+		t.Errorf("unexpected next cursor %+v (%v)", cur, err)
+	}
+	if next.Query().Get("fields") != "name" || next.Query().Get("offset") != "" {
+		t.Errorf("unexpected next link %q", results.Next)
+	}
+	if prev == nil || prev.Query().Get("cursor") == "" {
+		t.Errorf("unexpected prev link %q", results.Prev)
+	}
+
+	link := w.Header().Get("Link")
+	for _, rel := range []string{`rel="next"`, `rel="prev"`, `rel="first"`} {
+		if !strings.Contains(link, rel) {
+			t.Errorf("expected %s in Link header %q", rel, link)
+		}
+	}
+	if !strings.Contains(link, "<"+results.Next+`>; rel="next"`) {
+		t.Errorf("expected the Link header to match the body, got %q", link)
+	}
+
+	if strings.Contains(w.Body.String(), "/v0/") {
+		t.Error("expected no Orchestrate paths in the response")
+	}
+}
+
+func TestLinkOffset(t *testing.T) {
+	for _, test := range []struct {
+		link   string
+		offset int
+		ok     bool
+	}{
+		{"/v0/people?limit=10&offset=20&query=ada", 20, true},
+		{"/v0/people?limit=10&query=ada", 0, true},
+		{"/v0/people?limit=10&offset=ten&query=ada", 0, false},
+		{"%zz", 0, false},
+	} {
+		if offset, ok := linkOffset(test.link); offset != test.offset || ok != test.ok {
+			t.Errorf("linkOffset(%q) = %d, %t, expected %d, %t", test.link, offset, ok, test.offset, test.ok)
+		}
+	}
+}
+
+func TestSearchSignedLinks(t *testing.T) {
+	p := newTestProxy(t, signedConfig)
+	defer p.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		p.orchestrate.Put("reports", key, map[string]interface{}{"title": key})
+	}
+
+	signed, _ := signedurl.Sign([]byte("secret"), "/reports?limit=1", time.Now().Add(time.Minute))
+	results := decodeResults(t, p.get(signed, nil))
+
+	next, err := url.Parse(results.Next)
+	if err != nil || results.Next == "" {
+		t.Fatalf("expected a next link, got %q", results.Next)
+	}
+	if w := p.get(next.RequestURI(), nil); w.Code != 200 {
+		t.Errorf("expected the re-signed next link to be accepted, got %d", w.Code)
+	}
+}
+
+func TestSignedCursorReplay(t *testing.T) {
+	p := newTestProxy(t, `{
+		"collections": {"people": {"query_rules": {"allowed_fields": ["name"]}}},
+		"signing_secret": "secret"
+	}`)
+	defer p.Close()
+	seedPeople(p)
+
+	// Signing waives the query rules, but only for as long as the signature
+	// lasts.
+	signed, _ := signedurl.Sign([]byte("secret"), "/people?limit=1&query=active:true", time.Now().Add(time.Minute))
+	results := decodeResults(t, p.get(signed, nil))
+	next, err := url.Parse(results.Next)
+	if err != nil || results.Next == "" {
+		t.Fatalf("expected a next link, got %q", results.Next)
+	}
+
+	params := next.Query()
+	params.Del(signedurl.SignatureParam)
+	params.Del(signedurl.ExpiresParam)
+	w := p.get(next.Path+"?"+params.Encode(), nil)
+	if e := decodeError(t, w); w.Code != 403 || e.Code != "signature_required" {
+		t.Errorf("expected an unsigned replay of the cursor to be refused, got %d %+v", w.Code, e)
+	}
+
+	expired := encodeCursor(conf.cursorKey, &cursor{Collection: "people", Query: "active:true", Limit: 1, Signed: true, Expires: time.Now().Add(-time.Minute).Unix()})
+	resigned, _ := signedurl.Sign([]byte("secret"), "/people?cursor="+url.QueryEscape(expired), time.Now().Add(time.Minute))
+	w = p.get(resigned, nil)
+	if e := decodeError(t, w); w.Code != 403 || e.Code != "signature_expired" {
+		t.Errorf("expected an expired cursor to be refused, got %d %+v", w.Code, e)
+	}
+
+	// Unsigned cursors are held to the rules in force when they are used.
+	unchecked := encodeCursor(conf.cursorKey, &cursor{Collection: "people", Query: "active:true", Limit: 1})
+	w = p.get("/people?cursor="+url.QueryEscape(unchecked), nil)
+	if e := decodeError(t, w); w.Code != 400 || e.Code != "field_not_allowed" {
+		t.Errorf("expected the cursor's query to be checked, got %d %+v", w.Code, e)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/hoisie/web"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The prefix of every metric name.
+const metricsNamespace = "orchestrate_search_"
+
+// The upper bounds, in seconds, of the buckets of latency histograms.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The proxy's metrics, served at /metrics in the Prometheus text format.
+var (
+	metrics = new(metricRegistry)
+
+	requestsTotal = metrics.counter("requests_total",
+		"HTTP requests served, by collection, route and status.",
+		"collection", "route", "status")
+	requestsInFlight = metrics.gauge("requests_in_flight",
+		"HTTP requests currently being served.")
+	upstreamDuration = metrics.histogram("upstream_request_duration_seconds",
+		"Latency of requests to Orchestrate, by operation.",
+		latencyBuckets, "operation")
+	upstreamErrorsTotal = metrics.counter("upstream_errors_total",
+		"Failed requests to Orchestrate, by status code; network errors have status \"error\".",
+		"status")
+	cacheRequestsTotal = metrics.counter("cache_requests_total",
+		"Response cache lookups, by result.",
+		"result")
+	cacheHitRatio = metrics.gaugeFunc("cache_hit_ratio",
+		"The fraction of response cache lookups that were hits.",
+		func() float64 {
+			hits, misses := cacheRequestsTotal.value("hit"), cacheRequestsTotal.value("miss")
+			if hits+misses == 0 {
+				return 0
+			}
+			return hits / (hits + misses)
+		})
+	breakerStateGauge = metrics.gauge("circuit_breaker_state",
+		"1 for the current state of the circuit breaker around Orchestrate, 0 for the others.",
+		"state")
+	breakerTransitionsTotal = metrics.counter("circuit_breaker_transitions_total",
+		"Circuit breaker state changes, by the state entered.",
+		"state")
+	breakerRejectionsTotal = metrics.counter("circuit_breaker_rejections_total",
+		"Calls to Orchestrate refused because the circuit breaker was open.")
+	rateLimitRejectionsTotal = metrics.counter("rate_limit_rejections_total",
+		"Requests refused by a rate limit or daily quota, by limit.",
+		"limit")
+)
+
+// A set of metrics, written in the order they were registered.
+type metricRegistry struct {
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func (r *metricRegistry) counter(name, help string, labels ...string) *metricVec {
+	m := newMetricVec("counter", name, help, labels)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *metricRegistry) gauge(name, help string, labels ...string) *metricVec {
+	m := newMetricVec("gauge", name, help, labels)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *metricRegistry) gaugeFunc(name, help string, value func() float64) *gaugeFunc {
+	m := &gaugeFunc{name: metricsNamespace + name, help: help, value: value}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *metricRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	m := &histogramVec{name: metricsNamespace + name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Writes every metric in the Prometheus text format.
+func (r *metricRegistry) write(w io.Writer) {
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// A counter or gauge, with a value for each combination of label values.
+type metricVec struct {
+	kind, name, help string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(kind, name, help string, labels []string) *metricVec {
+	return &metricVec{kind: kind, name: metricsNamespace + name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// Adds delta to the value with the given label values.
+func (m *metricVec) add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	m.values[key] += delta
+	m.mu.Unlock()
+}
+
+// Sets the value with the given label values.
+func (m *metricVec) set(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+func (m *metricVec) inc(values ...string) {
+	m.add(1, values...)
+}
+
+// Returns the value with the given label values.
+func (m *metricVec) value(values ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[strings.Join(values, "\xff")]
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, m.name, m.help, m.kind)
+	if len(m.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", m.name, formatMetricValue(m.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, strings.Split(key, "\xff")), formatMetricValue(m.values[key]))
+	}
+}
+
+// A gauge whose value is computed when it is written.
+type gaugeFunc struct {
+	name, help string
+	value      func() float64
+}
+
+func (m *gaugeFunc) write(w io.Writer) {
+	writeMetricHeader(w, m.name, m.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", m.name, formatMetricValue(m.value()))
+}
+
+// A histogram, with a series for each combination of label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Records an observation in the series with the given label values.
+func (m *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.series[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.series[key] = h
+	}
+	for i, bound := range m.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (m *histogramVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, m.name, m.help, "histogram")
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := m.series[key]
+		values := strings.Split(key, "\xff")
+		labels := append(append([]string{}, m.labels...), "le")
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(labels, append(values, formatMetricValue(bound))), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(labels, append(values, "+Inf")), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, values), formatMetricValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, values), h.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Serves the metrics in the Prometheus text format.
+func serveMetrics(ctx *web.Context) {
+	buf := new(bytes.Buffer)
+	metrics.write(buf)
+
+	ctx.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8", true)
+	ctx.WriteHeader(200)
+	ctx.Write(buf.Bytes())
+}
+
+// An http.RoundTripper that records the latency and failures of requests to
+// Orchestrate.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	upstreamDuration.observe(time.Since(start).Seconds(), upstreamOperation(req))
+
+	switch {
+	case err != nil && req.Context().Err() == context.Canceled:
+		// The proxy gave up on the call; Orchestrate did nothing wrong.
+	case err != nil:
+		upstreamErrorsTotal.inc("error")
+	case resp.StatusCode >= 400:
+		upstreamErrorsTotal.inc(strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// Names the gorc operation a request to Orchestrate was made for, e.g. search
+// or get, from its method and path.
+func upstreamOperation(req *http.Request) string {
+	path := req.URL.Path
+	if i := strings.Index(path, "/v0/"); i >= 0 {
+		path = path[i+len("/v0/"):]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	method := strings.ToLower(req.Method)
+
+	switch {
+	case parts[0] == "":
+		return "ping"
+	case len(parts) == 1 && req.Method == "GET" && req.URL.Query().Get("query") != "":
+		return "search"
+	case len(parts) == 1 && req.Method == "GET":
+		return "list"
+	case len(parts) == 1:
+		return method + "_collection"
+	case len(parts) == 2:
+		return method
+	case parts[2] == "refs":
+		return method + "_ref"
+	case parts[2] == "events":
+		return method + "_events"
+	case parts[2] == "relation" || parts[2] == "relations":
+		return method + "_relations"
+	}
+	return "other"
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"github.com/orchestrate-io/gorc"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	r := new(metricRegistry)
+	counter := r.counter("test_total", "A test counter.", "name")
+	histogram := r.histogram("test_seconds", "A test histogram.", []float64{.1, 1}, "op")
+	r.gaugeFunc("test_ratio", "A test gauge.", func() float64 { return 0.5 })
+
+	counter.inc(`a"b`)
+	counter.add(2, "a")
+	histogram.observe(.5, "get")
+	histogram.observe(2, "get")
+
+	buf := new(bytes.Buffer)
+	r.write(buf)
+	expected := `# HELP orchestrate_search_test_total A test counter.
+# TYPE orchestrate_search_test_total counter
+orchestrate_search_test_total{name="a"} 2
+orchestrate_search_test_total{name="a\"b"} 1
+# HELP orchestrate_search_test_seconds A test histogram.
+# TYPE orchestrate_search_test_seconds histogram
+orchestrate_search_test_seconds_bucket{op="get",le="0.1"} 0
+orchestrate_search_test_seconds_bucket{op="get",le="1"} 1
+orchestrate_search_test_seconds_bucket{op="get",le="+Inf"} 2
+orchestrate_search_test_seconds_sum{op="get"} 2.5
+orchestrate_search_test_seconds_count{op="get"} 2
+# HELP orchestrate_search_test_ratio A test gauge.
+# TYPE orchestrate_search_test_ratio gauge
+orchestrate_search_test_ratio 0.5
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestUpstreamOperation(t *testing.T) {
+	for _, test := range []struct {
+		method, url, operation string
+	}{
+		{"HEAD", "https://api.orchestrate.io/v0/", "ping"},
+		{"GET", "https://api.orchestrate.io/v0/people?query=*&limit=10", "search"},
+		{"GET", "https://api.orchestrate.io/v0/people?limit=10", "list"},
+		{"DELETE", "https://api.orchestrate.io/v0/people?force=true", "delete_collection"},
+		{"GET", "https://api.orchestrate.io/v0/people/ada", "get"},
+		{"PUT", "https://api.orchestrate.io/v0/people/ada", "put"},
+		{"GET", "https://api.orchestrate.io/v0/people/ada/refs/abc", "get_ref"},
+		{"PUT", "https://api.orchestrate.io/v0/people/ada/events/visit", "put_events"},
+		{"GET", "https://api.orchestrate.io/v0/people/ada/relations/knows", "get_relations"},
+	} {
+		req, _ := http.NewRequest(test.method, test.url, nil)
+		if operation := upstreamOperation(req); operation != test.operation {
+			t.Errorf("%s %s: expected %s, got %s", test.method, test.url, test.operation, operation)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+	c = gorc.NewClientWithOptions("", &gorc.ClientOptions{
+		BaseURL:   p.orchestrate.APIURL(),
+		Transport: &metricsTransport{next: http.DefaultTransport},
+	})
+
+	requests := requestsTotal.value("people", "search", "200")
+	notFound := requestsTotal.value("", "search", "404")
+	hits := cacheRequestsTotal.value("hit")
+	upstream404s := upstreamErrorsTotal.value("404")
+	rejections := rateLimitRejectionsTotal.value("rate")
+
+	p.get("/people", nil)
+	p.get("/people", nil)
+	p.get("/nobody", nil)
+	for i := 0; i < 3; i++ {
+		p.get("/limited", nil)
+	}
+
+	if n := requestsTotal.value("people", "search", "200") - requests; n != 2 {
+		t.Errorf("expected 2 successful searches to be counted, got %v", n)
+	}
+	if n := requestsTotal.value("", "search", "404") - notFound; n != 1 {
+		t.Errorf("expected the unknown collection to be counted without its name, got %v", n)
+	}
+	if n := cacheRequestsTotal.value("hit") - hits; n != 1 {
+		t.Errorf("expected a cache hit, got %v", n)
+	}
+	if n := upstreamErrorsTotal.value("404") - upstream404s; n != 2 {
+		t.Errorf("expected 2 upstream 404s from the unseeded collection, got %v", n)
+	}
+	if n := rateLimitRejectionsTotal.value("rate") - rejections; n != 1 {
+		t.Errorf("expected a rate limit rejection, got %v", n)
+	}
+	if requestsInFlight.value() != 0 {
+		t.Errorf("expected no requests in flight, got %v", requestsInFlight.value())
+	}
+
+	w := p.get("/metrics", nil)
+	body := w.Body.String()
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("expected metrics, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`orchestrate_search_requests_total{collection="people",route="search",status="200"}`,
+		`orchestrate_search_upstream_request_duration_seconds_count{operation="search"}`,
+		"# TYPE orchestrate_search_cache_hit_ratio gauge",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/orchestrate-io/gorc"
+	"sort"
+	"strings"
+)
+
+// The most paths a fields parameter may select.
+const maxFields = 32
+
+// A dot separated path into a JSON value, e.g. address.city. Paths descend
+// into every element of the arrays they cross.
+type fieldPath []string
+
+// Parses a comma separated list of paths. Duplicates are removed and the
+// result is sorted, so equivalent lists parse identically.
+func parseFields(param string) ([]fieldPath, error) {
+	names, err := parseFieldNames(param)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return splitPaths(names), nil
+}
+
+// Parses a comma separated list of paths, keeping the order of their first
+// appearance.
+func parseFieldNames(param string) ([]string, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+			return nil, fmt.Errorf("invalid field %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > maxFields {
+		return nil, fmt.Errorf("at most %d fields may be selected", maxFields)
+	}
+	return names, nil
+}
+
+func splitPaths(names []string) []fieldPath {
+	paths := make([]fieldPath, len(names))
+	for i, name := range names {
+		paths[i] = strings.Split(name, ".")
+	}
+	return paths
+}
+
+// Returns the canonical form of a list of paths.
+func joinFields(paths []fieldPath) string {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = strings.Join(path, ".")
+	}
+	return strings.Join(names, ",")
+}
+
+// Narrows each result's value to the selected paths, if any, and then strips
+// the redacted paths. Selecting a redacted path does not reveal it.
+func shapeResults(results *gorc.SearchResults, fields, redact []fieldPath) error {
+	if len(fields) == 0 && len(redact) == 0 {
+		return nil
+	}
+
+	for i := range results.Results {
+		value, err := shapeValue(results.Results[i].RawValue, fields, redact)
+		if err != nil {
+			return err
+		}
+		results.Results[i].RawValue = value
+	}
+	return nil
+}
+
+// Narrows a raw JSON value to the selected paths, if any, and strips the
+// redacted paths. Numbers keep their original text.
+func shapeValue(raw json.RawMessage, fields, redact []fieldPath) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	if len(fields) > 0 {
+		var ok bool
+		if value, ok = project(value, fields); !ok {
+			value = map[string]interface{}{}
+		}
+	}
+	for _, path := range redact {
+		strip(value, path)
+	}
+
+	return json.Marshal(value)
+}
+
+// Returns the parts of a value selected by paths, and whether anything was
+// selected.
+func project(value interface{}, paths []fieldPath) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		children := map[string][]fieldPath{}
+		whole := map[string]bool{}
+		for _, path := range paths {
+			if len(path) == 1 {
+				whole[path[0]] = true
+			} else {
+				children[path[0]] = append(children[path[0]], path[1:])
+			}
+		}
+
+		result := map[string]interface{}{}
+		for name := range whole {
+			if child, ok := v[name]; ok {
+				result[name] = child
+			}
+		}
+		for name, rest := range children {
+			if whole[name] {
+				continue
+			}
+			if child, ok := project(v[name], rest); ok {
+				result[name] = child
+			}
+		}
+		return result, len(result) > 0
+	case []interface{}:
+		result := []interface{}{}
+		for _, element := range v {
+			if projected, ok := project(element, paths); ok {
+				result = append(result, projected)
+			}
+		}
+		return result, len(result) > 0
+	}
+	return nil, false
+}
+
+// Removes the value at a path, in place.
+func strip(value interface{}, path fieldPath) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+		} else if child, ok := v[path[0]]; ok {
+			strip(child, path[1:])
+		}
+	case []interface{}:
+		for _, element := range v {
+			strip(element, path)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+const shapeDocument = `{
+	"name": "Ada",
+	"email": "ada@example.com",
+	"age": 36.0,
+	"address": {"city": "London", "postcode": "W1"},
+	"notes": [{"text": "hi", "author": "charles"}, {"text": "yo", "author": "grace"}]
+}`
+
+func TestShapeValue(t *testing.T) {
+	for _, test := range []struct {
+		fields, redact string
+		expected       string
+	}{
+		{"", "", `{"address":{"city":"London","postcode":"W1"},"age":36.0,"email":"ada@example.com","name":"Ada","notes":[{"author":"charles","text":"hi"},{"author":"grace","text":"yo"}]}`},
+		{"name,age", "", `{"age":36.0,"name":"Ada"}`},
+		{"address.city", "", `{"address":{"city":"London"}}`},
+		{"address,address.city", "", `{"address":{"city":"London","postcode":"W1"}}`},
+		{"notes.text", "", `{"notes":[{"text":"hi"},{"text":"yo"}]}`},
+		{"missing,name.first", "", `{}`},
+		{"", "email,notes.author", `{"address":{"city":"London","postcode":"W1"},"age":36.0,"name":"Ada","notes":[{"text":"hi"},{"text":"yo"}]}`},
+		{"name,email", "email", `{"name":"Ada"}`},
+		{"address", "address.postcode", `{"address":{"city":"London"}}`},
+	} {
+		fields, err := parseFields(test.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var redact []fieldPath
+		if test.redact != "" {
+			redact, _ = parseFields(test.redact)
+		}
+
+		shaped, err := shapeValue([]byte(shapeDocument), fields, redact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(shaped) != test.expected {
+			t.Errorf("fields=%q redact=%q: got %s, expected %s", test.fields, test.redact, shaped, test.expected)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields(" name , address.city,name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joinFields(fields) != "address.city,name" {
+		t.Errorf("expected sorted, deduplicated fields, got %q", joinFields(fields))
+	}
+
+	for _, param := range []string{"name,", ".name", "name.", "a..b"} {
+		if _, err := parseFields(param); err == nil {
+			t.Errorf("expected %q to be rejected", param)
+		}
+	}
+}
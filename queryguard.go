@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	// The longest query accepted when the collection does not say.
+	defaultMaxQueryLength = 512
+
+	// The most clauses accepted in a query when the collection does not say.
+	defaultMaxQueryClauses = 32
+)
+
+// The rules a client supplied query must follow before it is forwarded to
+// Orchestrate. Queries from the configuration and from signed URLs are
+// trusted and not checked.
+type queryRules struct {
+	// The longest query, in bytes.
+	MaxLength int `json:"max_length"`
+
+	// The most terms, phrases, ranges and regular expressions in a query.
+	MaxClauses int `json:"max_clauses"`
+
+	// The fields that may be searched. If set, queries may only name these
+	// fields, and unfielded terms, which search every field, are refused.
+	AllowedFields []string `json:"allowed_fields"`
+
+	// Whether terms may start with a wildcard, e.g. *son. These are
+	// expensive for Orchestrate to evaluate.
+	AllowLeadingWildcards bool `json:"allow_leading_wildcards"`
+
+	// Whether regular expressions, e.g. /jo.*n/, are allowed.
+	AllowRegex bool `json:"allow_regex"`
+
+	// Whether fuzzy terms, e.g. jon~, are allowed.
+	AllowFuzzy bool `json:"allow_fuzzy"`
+
+	allowed map[string]bool
+}
+
+// Fills in defaults for unset fields and validates the rules.
+func (r *queryRules) init() error {
+	if r.MaxLength == 0 {
+		r.MaxLength = defaultMaxQueryLength
+	}
+	if r.MaxClauses == 0 {
+		r.MaxClauses = defaultMaxQueryClauses
+	}
+	if r.MaxLength < 1 || r.MaxClauses < 1 {
+		return fmt.Errorf("max_length and max_clauses must be positive")
+	}
+
+	if r.AllowedFields != nil {
+		r.allowed = map[string]bool{}
+		for _, field := range r.AllowedFields {
+			r.allowed[field] = true
+		}
+	}
+	return nil
+}
+
+// Checks a query against the rules, returning an error that names the rule it
+// breaks.
+func (r *queryRules) check(query string) *apiError {
+	if len(query) > r.MaxLength {
+		return newAPIError(400, "query_too_long", fmt.Sprintf("The query is longer than %d characters.", r.MaxLength))
+	}
+
+	analysis, err := analyzeQuery(query)
+	if err != nil {
+		return newAPIError(400, "invalid_query", fmt.Sprintf("The query could not be parsed: %s.", err))
+	}
+
+	switch {
+	case analysis.clauses > r.MaxClauses:
+		return newAPIError(400, "too_many_clauses", fmt.Sprintf("The query has more than %d clauses.", r.MaxClauses))
+	case analysis.regex != "" && !r.AllowRegex:
+		return newAPIError(400, "regex_not_allowed", fmt.Sprintf("Regular expressions such as %s are not allowed.", analysis.regex))
+	case analysis.fuzzy != "" && !r.AllowFuzzy:
+		return newAPIError(400, "fuzzy_not_allowed", fmt.Sprintf("Fuzzy terms such as %s are not allowed.", analysis.fuzzy))
+	case analysis.leadingWildcard != "" && !r.AllowLeadingWildcards:
+		return newAPIError(400, "leading_wildcard_not_allowed", fmt.Sprintf("Terms may not start with a wildcard, as %s does.", analysis.leadingWildcard))
+	}
+
+	if r.allowed != nil {
+		if analysis.unfielded {
+			return newAPIError(400, "field_required", "Every term must name the field it searches.")
+		}
+		for _, field := range analysis.fields {
+			if !r.allowed[field] {
+				return newAPIError(400, "field_not_allowed", fmt.Sprintf("The field %q may not be searched.", field))
+			}
+		}
+	}
+
+	return nil
+}
+
+// What a query asks of Orchestrate.
+type queryAnalysis struct {
+	// The number of terms, phrases, ranges and regular expressions.
+	clauses int
+
+	// The fields named by the query, in order of first use.
+	fields []string
+
+	// Whether any clause searches every field.
+	unfielded bool
+
+	// The first offending term of each kind, if any.
+	leadingWildcard string
+	regex           string
+	fuzzy           string
+}
+
+// Parses a query in the Lucene query parser syntax and reports what it asks
+// of Orchestrate.
+func analyzeQuery(query string) (*queryAnalysis, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &queryAnalyzer{tokens: tokens, analysis: &queryAnalysis{}}
+	if err := a.clauses("", false); err != nil {
+		return nil, err
+	}
+	return a.analysis, nil
+}
+
+// The kinds of lexical token in a query.
+type queryTokenKind int
+
+const (
+	queryTerm queryTokenKind = iota
+	queryPhrase
+	queryRange
+	queryRegex
+	queryLParen
+	queryRParen
+	queryColon
+	queryOperator
+	queryPrefix
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+
+	// Set for terms followed by ~, which makes them fuzzy.
+	fuzzy bool
+
+	// Set for terms that start with an unescaped wildcard.
+	leadingWildcard bool
+}
+
+// Splits a query into tokens. Boosts (^2) and phrase slop ("a b"~2) are
+// accepted and discarded.
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: queryLParen})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: queryRParen})
+			i++
+			i = skipModifiers(runes, i)
+		case r == ':':
+			tokens = append(tokens, queryToken{kind: queryColon})
+			i++
+		case r == '+' || r == '-' || r == '!':
+			if r == '!' || (i+1 < len(runes) && !unicode.IsSpace(runes[i+1])) {
+				tokens = append(tokens, queryToken{kind: queryPrefix})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected %q", r)
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("unexpected %q", r)
+			}
+			tokens = append(tokens, queryToken{kind: queryOperator})
+			i += 2
+		case r == '"' || r == '/':
+			end, err := closing(runes, i, r)
+			if err != nil {
+				return nil, err
+			}
+			kind := queryPhrase
+			if r == '/' {
+				kind = queryRegex
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: string(runes[i : end+1])})
+			i = skipModifiers(runes, end+1)
+		case r == '[' || r == '{':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated range")
+			}
+			if parts := strings.Fields(string(runes[i+1 : end])); len(parts) != 3 || parts[1] != "TO" {
+				return nil, fmt.Errorf("invalid range %s", string(runes[i:end+1]))
+			}
+			tokens = append(tokens, queryToken{kind: queryRange, text: string(runes[i : end+1])})
+			i = skipModifiers(runes, end+1)
+		default:
+			token := queryToken{kind: queryTerm}
+			start := i
+			for i < len(runes) && !termEnds(runes, i) {
+				if runes[i] == '\\' {
+					i++
+				} else if i == start && (runes[i] == '*' || runes[i] == '?') {
+					token.leadingWildcard = true
+				}
+				i++
+			}
+			if i > len(runes) {
+				return nil, fmt.Errorf("query ends with an escape character")
+			}
+			token.text = string(runes[start:i])
+			if i < len(runes) && runes[i] == '~' {
+				token.fuzzy = true
+			}
+			i = skipModifiers(runes, i)
+
+			switch token.text {
+			case "AND", "OR", "&&", "||":
+				token.kind = queryOperator
+			case "NOT":
+				token.kind = queryPrefix
+			case "":
+				return nil, fmt.Errorf("unexpected %q", runes[start])
+			}
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// Reports whether the unescaped rune at i ends a term. Like Lucene's classic
+// parser, this includes the start of a regular expression, so x/y/ is the
+// term x followed by the regular expression /y/.
+func termEnds(runes []rune, i int) bool {
+	r := runes[i]
+	switch {
+	case unicode.IsSpace(r) || strings.ContainsRune(`()":^~[]{}/!`, r):
+		return true
+	case r == '&' || r == '|':
+		return i+1 < len(runes) && runes[i+1] == r
+	}
+	return false
+}
+
+// Returns the index of the unescaped delimiter closing the phrase or regular
+// expression that starts at i.
+func closing(runes []rune, i int, delimiter rune) (int, error) {
+	for end := i + 1; end < len(runes); end++ {
+		if runes[end] == '\\' {
+			end++
+		} else if runes[end] == delimiter {
+			return end, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated %c", delimiter)
+}
+
+// Skips any boost (^2) or slop/fuzziness (~, ~2, ~0.8) following a clause.
+func skipModifiers(runes []rune, i int) int {
+	for i < len(runes) && (runes[i] == '^' || runes[i] == '~') {
+		i++
+		for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+			i++
+		}
+	}
+	return i
+}
+
+type queryAnalyzer struct {
+	tokens   []queryToken
+	pos      int
+	analysis *queryAnalysis
+}
+
+func (a *queryAnalyzer) peek() *queryToken {
+	if a.pos < len(a.tokens) {
+		return &a.tokens[a.pos]
+	}
+	return nil
+}
+
+// Analyzes a sequence of clauses up to the end of the query, or up to the
+// closing parenthesis of a group. Field is the field named before the group,
+// if any.
+func (a *queryAnalyzer) clauses(field string, group bool) error {
+	count := 0
+	for {
+		t := a.peek()
+		if t == nil {
+			if group {
+				return fmt.Errorf("missing closing parenthesis")
+			}
+			break
+		}
+		if t.kind == queryRParen {
+			if !group {
+				return fmt.Errorf("unexpected closing parenthesis")
+			}
+			a.pos++
+			break
+		}
+
+		if t.kind == queryOperator {
+			if count == 0 {
+				return fmt.Errorf("unexpected operator")
+			}
+			a.pos++
+		}
+		for t = a.peek(); t != nil && t.kind == queryPrefix; t = a.peek() {
+			a.pos++
+		}
+
+		if err := a.clause(field); err != nil {
+			return err
+		}
+		count++
+	}
+
+	if count == 0 {
+		return fmt.Errorf("empty query")
+	}
+	return nil
+}
+
+// Analyzes a single clause: a term, phrase, range, regular expression, field
+// query or group.
+func (a *queryAnalyzer) clause(field string) error {
+	t := a.peek()
+	if t == nil {
+		return fmt.Errorf("unexpected end of query")
+	}
+	a.pos++
+
+	switch t.kind {
+	case queryLParen:
+		return a.clauses(field, true)
+	case queryTerm:
+		if next := a.peek(); next != nil && next.kind == queryColon {
+			a.pos++
+			if field != "" {
+				return fmt.Errorf("nested field %q", t.text)
+			}
+			if t.text == "*" {
+				if value := a.peek(); value == nil || value.kind != queryTerm || value.text != "*" {
+					return fmt.Errorf("only *:* may use the * field")
+				}
+				a.pos++
+				a.analysis.clauses++
+				return nil
+			}
+			return a.clause(t.text)
+		}
+		if t.text == "*" && field == "" {
+			a.analysis.clauses++
+			return nil
+		}
+		if t.leadingWildcard && t.text != "*" && a.analysis.leadingWildcard == "" {
+			a.analysis.leadingWildcard = t.text
+		}
+		if t.fuzzy && a.analysis.fuzzy == "" {
+			a.analysis.fuzzy = t.text + "~"
+		}
+	case queryRegex:
+		if a.analysis.regex == "" {
+			a.analysis.regex = t.text
+		}
+	case queryPhrase, queryRange:
+	default:
+		return fmt.Errorf("unexpected token")
+	}
+
+	a.analysis.clauses++
+	a.useField(field)
+	return nil
+}
+
+// Records a clause searching a field, or every field if field is empty.
+func (a *queryAnalyzer) useField(field string) {
+	if field == "" {
+		a.analysis.unfielded = true
+		return
+	}
+	for _, f := range a.analysis.fields {
+		if f == field {
+			return
+		}
+	}
+	a.analysis.fields = append(a.analysis.fields, field)
+}
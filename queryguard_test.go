@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeQuery(t *testing.T) {
+	for query, expected := range map[string]queryAnalysis{
+		"ada":                            {clauses: 1, unfielded: true},
+		"*":                              {clauses: 1},
+		"*:*":                            {clauses: 1},
+		"name:ada AND age:[18 TO 65]":    {clauses: 2, fields: []string{"name", "age"}},
+		"name:(ada OR grace) -name:alan": {clauses: 3, fields: []string{"name"}},
+		`bio:"first published"~2^3`:      {clauses: 1, fields: []string{"bio"}},
+		"name:*lace":                     {clauses: 1, fields: []string{"name"}, leadingWildcard: "*lace"},
+		"name:lov*":                      {clauses: 1, fields: []string{"name"}},
+		`name:\*lace`:                    {clauses: 1, fields: []string{"name"}},
+		"name:/ad[ao]/":                  {clauses: 1, fields: []string{"name"}, regex: "/ad[ao]/"},
+		"name:adda~0.8":                  {clauses: 1, fields: []string{"name"}, fuzzy: "adda~"},
+		"+a && !b || NOT c":              {clauses: 3, unfielded: true},
+		"a&&b||c!d":                      {clauses: 4, unfielded: true},
+		"name:x/.*/":                     {clauses: 2, fields: []string{"name"}, unfielded: true, regex: "/.*/"},
+		"name:x /.*/":                    {clauses: 2, fields: []string{"name"}, unfielded: true, regex: "/.*/"},
+		"x/y/":                           {clauses: 2, unfielded: true, regex: "/y/"},
+	} {
+		analysis, err := analyzeQuery(query)
+		if err != nil {
+			t.Errorf("analyzeQuery(%q): %s", query, err)
+			continue
+		}
+		if analysis.clauses != expected.clauses ||
+			strings.Join(analysis.fields, ",") != strings.Join(expected.fields, ",") ||
+			analysis.unfielded != expected.unfielded ||
+			analysis.leadingWildcard != expected.leadingWildcard ||
+			analysis.regex != expected.regex ||
+			analysis.fuzzy != expected.fuzzy {
+			t.Errorf("analyzeQuery(%q) = %+v, expected %+v", query, *analysis, expected)
+		}
+	}
+}
+
+func TestAnalyzeQueryInvalid(t *testing.T) {
+	for _, query := range []string{"(ada", "ada)", `"ada`, "/ada", "age:[1 TO", "age:[1 2]", "AND ada", "name:", "a:b:c", "]", `ada\`} {
+		if _, err := analyzeQuery(query); err == nil {
+			t.Errorf("expected %q to be rejected", query)
+		}
+	}
+}
+
+func TestQueryRules(t *testing.T) {
+	rules := &queryRules{MaxLength: 40, MaxClauses: 3, AllowedFields: []string{"name", "age"}}
+	if err := rules.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, code := range map[string]string{
+		"name:ada":                      "",
+		"name:ada AND age:[18 TO 65]":   "",
+		"*":                             "",
+		strings.Repeat("name:a ", 10):   "query_too_long",
+		"name:a name:b name:c name:d":   "too_many_clauses",
+		"name:/ad[ao]/":                 "regex_not_allowed",
+		"name:x/.*/":                    "regex_not_allowed",
+		"name:x /.*/":                   "regex_not_allowed",
+		"x/y/":                          "regex_not_allowed",
+		"name:ada~":                     "fuzzy_not_allowed",
+		"name:*da":                      "leading_wildcard_not_allowed",
+		"ada":                           "field_required",
+		"email:ada@example.com":         "field_not_allowed",
+		"name:ada OR salary:[100 TO *]": "field_not_allowed",
+		"name:(ada":                     "invalid_query",
+	} {
+		e := rules.check(query)
+		if code == "" && e != nil {
+			t.Errorf("expected %q to be accepted, got %s", query, e)
+		} else if code != "" && (e == nil || e.Code != code || e.Status != 400) {
+			t.Errorf("expected %q to be rejected with %s, got %v", query, code, e)
+		}
+	}
+}
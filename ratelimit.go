@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hoisie/web"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often the in-memory store discards idle buckets.
+const bucketSweepInterval = time.Minute
+
+// A token bucket rate limit: clients may make Requests requests every Per,
+// with bursts of up to Burst requests.
+type rateLimit struct {
+	Requests int      `json:"requests"`
+	Per      duration `json:"per"`
+	Burst    int      `json:"burst"`
+}
+
+// Fills in defaults for unset fields and validates the limit.
+func (l *rateLimit) init() error {
+	if l.Per.Duration == 0 {
+		l.Per.Duration = time.Minute
+	}
+	if l.Burst == 0 {
+		l.Burst = l.Requests
+	}
+
+	if l.Requests < 1 {
+		return fmt.Errorf("requests must be positive")
+	}
+	if l.Burst < 1 {
+		return fmt.Errorf("burst must be positive")
+	}
+	return nil
+}
+
+// Returns the number of tokens added to a bucket each second.
+func (l *rateLimit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// The outcome of taking a token from a bucket.
+type rateDecision struct {
+	// Whether the request may proceed.
+	allowed bool
+
+	// The whole tokens left in the bucket.
+	remaining int
+
+	// How long until the bucket is full again.
+	reset time.Duration
+
+	// How long until a token is available, when the request was refused.
+	retryAfter time.Duration
+}
+
+// Holds token buckets. The in-memory store limits each dyno independently; a
+// store backed by a shared service can be plugged in to apply limits across
+// all dynos.
+type limiterStore interface {
+	// Takes a token from the bucket for key, which refills according to
+	// limit.
+	take(key string, limit *rateLimit, now time.Time) (rateDecision, error)
+
+	// Increments the counter for key and returns its new value. The counter
+	// is discarded once expires has passed.
+	count(key string, expires time.Time) (int64, error)
+}
+
+// A limiterStore that keeps buckets in process memory.
+type memoryLimiterStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   *rateLimit
+}
+
+type counter struct {
+	n       int64
+	expires time.Time
+}
+
+func newMemoryLimiterStore() *memoryLimiterStore {
+	return &memoryLimiterStore{
+		buckets:  map[string]*bucket{},
+		counters: map[string]*counter{},
+	}
+}
+
+func (s *memoryLimiterStore) take(key string, limit *rateLimit, now time.Time) (rateDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > bucketSweepInterval {
+		s.sweep(now)
+	}
+
+	b := s.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	var decision rateDecision
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.allowed = true
+	} else {
+		decision.retryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+	decision.remaining = int(b.tokens)
+	decision.reset = seconds((float64(limit.Burst) - b.tokens) / limit.rate())
+
+	return decision, nil
+}
+
+func (s *memoryLimiterStore) count(key string, expires time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.counters[key]
+	if c == nil || !time.Now().Before(c.expires) {
+		c = &counter{expires: expires}
+		s.counters[key] = c
+	}
+	c.n++
+
+	return c.n, nil
+}
+
+// Discards buckets that have refilled completely, since a new bucket would be
+// identical, and expired counters. The caller must hold the lock.
+func (s *memoryLimiterStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, c := range s.counters {
+		if !now.Before(c.expires) {
+			delete(s.counters, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Adds the tokens earned since the bucket was last updated.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.rate())
+		b.updated = now
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Applies a rate limit to the client making a request, setting the
+// X-RateLimit-* headers. If the client is over the limit a 429 is written and
+// false is returned. A failing store lets requests through.
+func checkRateLimit(ctx *web.Context, store limiterStore, key string, limit *rateLimit) bool {
+	if limit == nil {
+		return true
+	}
+
+	now := time.Now()
+	decision, err := store.take(key, limit, now)
+	if err != nil {
+		logError(ctx, "rate limiter failed, allowing request: %s", err)
+		return true
+	}
+
+	ctx.SetHeader("X-RateLimit-Limit", strconv.Itoa(limit.Burst), true)
+	ctx.SetHeader("X-RateLimit-Remaining", strconv.Itoa(decision.remaining), true)
+	ctx.SetHeader("X-RateLimit-Reset", strconv.FormatInt(now.Add(decision.reset).Unix(), 10), true)
+
+	if decision.allowed {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(decision.retryAfter.Seconds()))
+	ctx.SetHeader("Retry-After", strconv.Itoa(retryAfter), true)
+	rateLimitRejectionsTotal.inc("rate")
+	writeError(ctx, newAPIError(429, "rate_limited", fmt.Sprintf("Too many requests; retry in %d seconds.", retryAfter)))
+	return false
+}
+
+// Counts a request against the caller's daily quota, setting the X-Quota-*
+// headers. If the quota is used up a 429 is written and false is returned. A
+// failing store lets requests through.
+func checkQuota(ctx *web.Context, store limiterStore, who *caller) bool {
+	quota := who.tier.DailyQuota
+	if quota == 0 {
+		return true
+	}
+
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+
+	used, err := store.count("quota\x00"+who.id+"\x00"+now.Format("2006-01-02"), midnight)
+	if err != nil {
+		logError(ctx, "quota store failed, allowing request: %s", err)
+		return true
+	}
+
+	remaining := quota - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	ctx.SetHeader("X-Quota-Limit", strconv.FormatInt(quota, 10), true)
+	ctx.SetHeader("X-Quota-Remaining", strconv.FormatInt(remaining, 10), true)
+	ctx.SetHeader("X-Quota-Reset", strconv.FormatInt(midnight.Unix(), 10), true)
+
+	if used <= quota {
+		return true
+	}
+
+	ctx.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))), true)
+	rateLimitRejectionsTotal.inc("quota")
+	writeError(ctx, newAPIError(429, "quota_exceeded", "The daily request quota has been used up."))
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterStore(t *testing.T) {
+	limit := &rateLimit{Requests: 2, Per: duration{time.Second}, Burst: 3}
+	store := newMemoryLimiterStore()
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		decision, _ := store.take("client", limit, now)
+		if !decision.allowed || decision.remaining != i {
+			t.Fatalf("expected request to be allowed with %d remaining, got %+v", i, decision)
+		}
+	}
+
+	decision, _ := store.take("client", limit, now)
+	if decision.allowed || decision.retryAfter != 500*time.Millisecond {
+		t.Errorf("expected to be refused for 500ms, got %+v", decision)
+	}
+
+	if decision, _ := store.take("other", limit, now); !decision.allowed {
+		t.Error("expected buckets to be independent")
+	}
+
+	if decision, _ := store.take("client", limit, now.Add(500*time.Millisecond)); !decision.allowed {
+		t.Error("expected a token after 500ms")
+	}
+
+	store.sweep(now.Add(time.Hour))
+	if len(store.buckets) != 0 {
+		t.Errorf("expected full buckets to be swept, %d remain", len(store.buckets))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, test := range []struct {
+		forwarded []string
+		hops      int
+		expected  string
+	}{
+		{nil, 1, "10.0.0.1"},
+		{[]string{"1.2.3.4"}, 1, "1.2.3.4"},
+		{[]string{"6.6.6.6, 1.2.3.4"}, 1, "1.2.3.4"},
+		{[]string{"6.6.6.6", "1.2.3.4"}, 1, "1.2.3.4"},
+		{[]string{"6.6.6.6, 1.2.3.4, 10.1.1.1"}, 2, "1.2.3.4"},
+		{[]string{"1.2.3.4"}, 0, "10.0.0.1"},
+	} {
+		req := &http.Request{RemoteAddr: "10.0.0.1:5000", Header: http.Header{}}
+		req.Header["X-Forwarded-For"] = test.forwarded
+		if ip := clientIP(req, test.hops); ip != test.expected {
+			t.Errorf("clientIP(%v, %d) = %q, expected %q", test.forwarded, test.hops, ip, test.expected)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hoisie/web"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// The header carrying the request id. The Heroku router sets it on every
+	// request, and the proxy echoes it back on the response.
+	requestIDHeader = "X-Request-Id"
+
+	// The longest request id accepted from the router.
+	maxRequestIDLength = 200
+)
+
+// Returns the id of the request being served. The id assigned by the router is
+// used when present, otherwise a random one is generated. Either way it is
+// set on the response.
+func requestID(ctx *web.Context) string {
+	if id := ctx.Header().Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	id := ctx.Request.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	ctx.SetHeader(requestIDHeader, id, true)
+	return id
+}
+
+// Checks that a request id only holds characters that are safe to log and
+// echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.' || r == '+' || r == '/' || r == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Generates a random request id.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Returns the IP address of the client making a request. Each of the hops
+// proxies in front of the application appends the address it received the
+// request from to X-Forwarded-For, so the entry hops from the end is the one
+// added by the outermost proxy. Entries before it were supplied by the client
+// and can not be trusted.
+func clientIP(req *http.Request, hops int) string {
+	if hops > 0 {
+		var addrs []string
+		for _, header := range req.Header["X-Forwarded-For"] {
+			for _, addr := range strings.Split(header, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+		if len(addrs) >= hops {
+			return addrs[len(addrs)-hops]
+		} else if len(addrs) > 0 {
+			return addrs[0]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Writes a JSON response with the given status.
+func writeJSON(ctx *web.Context, status int, value interface{}) {
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(value); err != nil {
+		status = 500
+		buf.Reset()
+		buf.WriteString(`{"error":{"status":500,"code":"internal_error","message":"The response could not be encoded."}}` + "\n")
+	}
+
+	writeBody(ctx, status, buf.Bytes())
+}
+
+// Writes an already encoded JSON response with the given status. Responses to
+// JSONP requests are wrapped in their callback.
+func writeBody(ctx *web.Context, status int, body []byte) {
+	if callback := jsonpCallback(ctx); callback != "" {
+		writeJSONP(ctx, callback, body)
+		return
+	}
+
+	ctx.ContentType("json")
+	ctx.WriteHeader(status)
+	ctx.Write(body)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hoisie/web"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a search may wait for Orchestrate. Heroku's router gives up on a
+// request that has not responded within 30 seconds, so there is no point in
+// waiting any longer.
+const searchTimeout = 30 * time.Second
+
+// A search that has been admitted: the caller may search the collection and
+// is within its limits.
+type searchRequest struct {
+	collection string
+	policy     *collectionPolicy
+	caller     *caller
+	query      string
+	limit      int
+	offset     int
+	fields     []fieldPath
+
+	// Whether the request was authorized by a signed URL.
+	signed bool
+}
+
+// Checks that the caller of a request may search a collection and resolves
+// the search parameters under the collection's policy and the caller's tier.
+// If the request is refused then the error response has been written and nil
+// is returned.
+func admitSearch(ctx *web.Context, collection string) *searchRequest {
+	collection = strings.TrimSuffix(collection, "/")
+
+	who, e := identify(ctx)
+	if e != nil {
+		writeError(ctx, e)
+		return nil
+	}
+
+	// A valid signature authorizes the search on its own, so signed URLs
+	// work whatever the caller's tier.
+	signed, e := checkSignature(ctx)
+	if e != nil {
+		writeError(ctx, e)
+		return nil
+	}
+
+	policy := conf.collection(collection)
+	if policy == nil {
+		writeError(ctx, errCollectionNotFound())
+		return nil
+	}
+	if policy.SignedOnly && !signed {
+		writeError(ctx, newAPIError(403, "signature_required", "This collection can only be searched through signed URLs."))
+		return nil
+	}
+	if !signed && !who.tier.allows(collection) {
+		writeError(ctx, errCollectionNotFound())
+		return nil
+	}
+
+	limit := who.tier.RateLimit
+	if limit == nil {
+		limit = policy.RateLimit
+	}
+	if limit == nil {
+		limit = who.tier.fallbackRateLimit
+	}
+	if !checkRateLimit(ctx, limiter, who.id+"\x00"+collection, limit) || !checkQuota(ctx, limiter, who) {
+		return nil
+	}
+
+	// A cursor carries the search it continues, so the query, limit and
+	// offset parameters are ignored.
+	var cur *cursor
+	if token := ctx.Params["cursor"]; token != "" {
+		var err error
+		if cur, err = decodeCursor(conf.cursorKey, token); err != nil || cur.Collection != collection {
+			writeError(ctx, newAPIError(400, "invalid_cursor", "The cursor is not valid."))
+			return nil
+		}
+		if cur.Signed && !signed {
+			writeError(ctx, newAPIError(403, "signature_required", "This cursor can only be used through signed URLs."))
+			return nil
+		}
+		if cur.Signed && time.Now().Unix() > cur.Expires {
+			writeError(ctx, newAPIError(403, "signature_expired", "The signed URL has expired."))
+			return nil
+		}
+		// The query was checked when the cursor was made, but the rules may
+		// have changed since.
+		if !signed && cur.Query != policy.DefaultQuery {
+			if e := policy.QueryRules.check(cur.Query); e != nil {
+				writeError(ctx, e)
+				return nil
+			}
+		}
+		if cur.Limit > policy.MaxLimit {
+			cur.Limit = policy.MaxLimit
+		}
+	} else {
+		if query := ctx.Params["query"]; !signed && strings.TrimSpace(query) != "" {
+			if e := policy.QueryRules.check(query); e != nil {
+				writeError(ctx, e)
+				return nil
+			}
+		}
+
+		offset, err := strconv.Atoi(ctx.Params["offset"])
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		cur = &cursor{
+			Collection: collection,
+			Query:      policy.query(ctx.Params["query"]),
+			Limit:      policy.limit(ctx.Params["limit"]),
+			Offset:     offset,
+		}
+	}
+
+	if cur.Offset > policy.MaxOffset {
+		writeError(ctx, newAPIError(400, "offset_too_large", fmt.Sprintf("Searches can not page beyond offset %d.", policy.MaxOffset)))
+		return nil
+	}
+
+	fields, err := parseFields(ctx.Params["fields"])
+	if err != nil {
+		writeError(ctx, newAPIError(400, "invalid_fields", fmt.Sprintf("The fields parameter is not valid: %s.", err)))
+		return nil
+	}
+
+	req := &searchRequest{
+		collection: collection,
+		policy:     policy,
+		caller:     who,
+		query:      cur.Query,
+		limit:      who.tier.clamp(cur.Limit),
+		offset:     cur.Offset,
+		fields:     fields,
+		signed:     signed,
+	}
+	accessEntryOf(ctx).search(req)
+	return req
+}
+
+func search(ctx *web.Context, collection string) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+
+	if e := checkCallback(ctx, collection); e != nil {
+		writeError(ctx, e)
+		return
+	}
+
+	req := admitSearch(ctx, collection)
+	if req == nil {
+		return
+	}
+
+	body, err := fetchResults(ctx, req)
+	if err != nil {
+		writeUpstreamError(ctx, err)
+		return
+	}
+
+	if body, err = rewriteLinks(ctx, req, body); err != nil {
+		writeUpstreamError(ctx, err)
+		return
+	}
+
+	if writeConditional(ctx, req.policy, body) {
+		return
+	}
+
+	writeBody(ctx, 200, body)
+}
+
+// Returns the encoded page of results for an admitted search, from the cache
+// if possible. If Orchestrate fails then an expired response is served, as
+// long as it is within the collection's max_stale. The X-Cache header reports
+// whether the cache was used. The call to Orchestrate is canceled once every
+// request waiting for it has gone, or after searchTimeout.
+func fetchResults(ctx *web.Context, req *searchRequest) ([]byte, error) {
+	key := searchCacheKey(req.collection, req.query, req.limit, req.offset, req.fields)
+	start := time.Now()
+	body, hit, err := responses.fetch(ctx.Request.Context(), key, req.policy.CacheTTL.Duration, func(loadCtx context.Context) ([]byte, error) {
+		loadCtx, cancel := context.WithTimeout(loadCtx, searchTimeout)
+		defer cancel()
+
+		results, err := c.SearchContext(loadCtx, req.collection, req.query, req.limit, req.offset)
+		if err != nil {
+			return nil, err
+		}
+		if err := shapeResults(results, req.fields, req.policy.redact); err != nil {
+			return nil, err
+		}
+
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(results); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+
+	if !hit {
+		accessEntryOf(ctx).upstream += time.Since(start)
+	}
+	if err != nil && ctx.Request.Context().Err() != nil {
+		if inflight.aborted() {
+			return nil, errShuttingDown()
+		}
+		return nil, errClientGone()
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	} else if err != nil && req.policy.MaxStale.Duration > 0 && servesStale(err) {
+		if stale, age, ok := responses.stale(key, req.policy.MaxStale.Duration); ok {
+			logError(ctx, "serving results %s stale: %s", age, err)
+			ctx.SetHeader("Warning", `110 - "Response is Stale"`, true)
+			ctx.SetHeader("X-Stale-Age", strconv.Itoa(int(age/time.Second)), true)
+			body, err, result = stale, nil, "stale"
+		}
+	}
+	ctx.SetHeader("X-Cache", strings.ToUpper(result), true)
+	if req.policy.CacheTTL.Duration > 0 {
+		cacheRequestsTotal.inc(result)
+	}
+	return body, err
+}
+
+// Reports whether a failure to fetch results means Orchestrate is in trouble,
+// in which case stale results are better than none: a timeout, a 5xx, a
+// refused connection or an open circuit breaker.
+func servesStale(err error) bool {
+	switch upstreamError(err).Code {
+	case "upstream_timeout", "upstream_error", "upstream_unavailable", "upstream_circuit_open":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"github.com/hoisie/web"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How long in-flight requests may run after a SIGTERM when the
+	// configuration does not say. Heroku kills the process 30 seconds after
+	// sending SIGTERM.
+	defaultShutdownGrace = 25 * time.Second
+
+	// How long streams are given to report their abort before the process
+	// exits.
+	abortGrace = time.Second
+
+	// How often draining checks for finished requests.
+	drainPoll = 50 * time.Millisecond
+)
+
+// The requests being served, so that shutdown can wait for them.
+var inflight = newInflightRequests()
+
+type inflightRequests struct {
+	mu       sync.Mutex
+	requests map[*trackedResponse]*inflightRequest
+	draining bool
+
+	// Closed when shutdown gives up waiting, telling streams to stop.
+	aborting chan struct{}
+}
+
+// What shutdown reports about a request it aborts.
+type inflightRequest struct {
+	id    string
+	route string
+	path  string
+	start time.Time
+
+	// Cancels the request's context, stopping any call to Orchestrate it is
+	// making.
+	cancel context.CancelFunc
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{requests: map[*trackedResponse]*inflightRequest{}, aborting: make(chan struct{})}
+}
+
+// Records that a request has started, giving it a context that abort cancels.
+// While draining, the response asks the client to close its connection, so
+// that it reconnects to another process.
+func (r *inflightRequests) add(ctx *web.Context, route string, w *trackedResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reqCtx, cancel := context.WithCancel(ctx.Request.Context())
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+	r.requests[w] = &inflightRequest{id: requestID(ctx), route: route, path: ctx.Request.URL.Path, start: w.entry.start, cancel: cancel}
+	if r.draining {
+		ctx.SetHeader("Connection", "close", true)
+	}
+}
+
+// Records that a request has finished.
+func (r *inflightRequests) remove(w *trackedResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req, ok := r.requests[w]; ok {
+		req.cancel()
+		delete(r.requests, w)
+	}
+}
+
+// Returns the number of requests in flight.
+func (r *inflightRequests) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.requests)
+}
+
+// Waits up to grace for every request to finish, returning those that
+// didn't, oldest first.
+func (r *inflightRequests) drain(grace time.Duration) []*inflightRequest {
+	r.mu.Lock()
+	r.draining = true
+	r.mu.Unlock()
+
+	deadline := time.Now().Add(grace)
+	for {
+		r.mu.Lock()
+		remaining := make([]*inflightRequest, 0, len(r.requests))
+		for _, req := range r.requests {
+			remaining = append(remaining, req)
+		}
+		r.mu.Unlock()
+
+		if len(remaining) == 0 || !time.Now().Before(deadline) {
+			sort.Sort(byStart(remaining))
+			return remaining
+		}
+		time.Sleep(drainPoll)
+	}
+}
+
+// Tells streams still running to stop, and cancels the calls to Orchestrate
+// that requests are waiting on.
+func (r *inflightRequests) abort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	close(r.aborting)
+	for _, req := range r.requests {
+		req.cancel()
+	}
+}
+
+// Reports whether shutdown has told streams to stop.
+func (r *inflightRequests) aborted() bool {
+	select {
+	case <-r.aborting:
+		return true
+	default:
+		return false
+	}
+}
+
+type byStart []*inflightRequest
+
+func (s byStart) Len() int           { return len(s) }
+func (s byStart) Less(i, j int) bool { return s[i].start.Before(s[j].start) }
+func (s byStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Stops accepting connections and waits up to grace for in-flight requests,
+// including exports, to finish. Requests still running after that are logged
+// and canceled, after which the process may exit.
+func shutdown(l net.Listener, grace time.Duration) {
+	logInfo(nil, "Shutting down; waiting up to %s for %d requests", grace, inflight.count())
+	l.Close()
+
+	remaining := inflight.drain(grace)
+	if len(remaining) == 0 {
+		logInfo(nil, "Shutdown complete; every request finished")
+		return
+	}
+
+	inflight.abort()
+	now := time.Now()
+	for _, req := range remaining {
+		logger.write(
+			logField{"level", "error"},
+			logField{"event", "aborted"},
+			logField{"request_id", req.id},
+			logField{"route", req.route},
+			logField{"path", req.path},
+			logField{"duration_ms", milliseconds(now.Sub(req.start))},
+		)
+	}
+	logError(nil, "Shutdown aborted %d requests still running after %s", len(remaining), grace)
+	inflight.drain(abortGrace)
+}
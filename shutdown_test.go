@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Blocks requests for the page at offset until the returned channel is
+// closed or the request is canceled.
+func blockPage(p *testProxy, offset string) chan struct{} {
+	release := make(chan struct{})
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("offset") == offset {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		return false
+	}
+	return release
+}
+
+// Waits until n requests are in flight.
+func waitInflight(t *testing.T, n int) {
+	for deadline := time.Now().Add(time.Second); inflight.count() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d requests in flight, got %d", n, inflight.count())
+		}
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	p := newTestProxy(t, exportConfig)
+	defer p.Close()
+	defer func() { inflight = newInflightRequests() }()
+	seedItems(p, 4)
+	release := blockPage(p, "2")
+
+	done := make(chan string)
+	go func() { done <- p.get("/items.ndjson", nil).Body.String() }()
+	waitInflight(t, 1)
+
+	drained := make(chan []*inflightRequest)
+	go func() { drained <- inflight.drain(time.Second) }()
+
+	time.Sleep(20 * time.Millisecond)
+	if w := p.get("/_health", nil); w.Header().Get("Connection") != "close" {
+		t.Error("expected requests during draining to close their connections")
+	}
+
+	close(release)
+	if body := <-done; strings.Count(body, "\n") != 4 {
+		t.Errorf("expected the export to finish, got %q", body)
+	}
+	if remaining := <-drained; len(remaining) != 0 {
+		t.Errorf("expected every request to finish, got %d", len(remaining))
+	}
+}
+
+func TestShutdownAborts(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {"items": {"max_limit": 2}}}`)
+	defer p.Close()
+	defer func() { inflight = newInflightRequests() }()
+	seedItems(p, 6)
+	blockPage(p, "2")
+
+	buf := new(bytes.Buffer)
+	logger = newLogger(buf, &logConfig{Format: "json"})
+
+	done := make(chan string)
+	go func() { done <- p.get("/items.ndjson", nil).Body.String() }()
+	waitInflight(t, 1)
+
+	// The page the export is waiting on is canceled rather than awaited.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shut := make(chan struct{})
+	go func() {
+		shutdown(l, 20*time.Millisecond)
+		close(shut)
+	}()
+
+	lines := strings.Split(strings.TrimSpace(<-done), "\n")
+	var envelope errorEnvelope
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &envelope); err != nil || envelope.Error == nil || envelope.Error.Code != "shutting_down" {
+		t.Errorf("expected the stream to end with a shutdown error, got %q", lines)
+	}
+	if len(lines) != 3 {
+		t.Errorf("expected 2 results before the error, got %d lines", len(lines))
+	}
+
+	<-shut
+	if _, err := l.Accept(); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+	if !strings.Contains(buf.String(), `"event":"aborted"`) || !strings.Contains(buf.String(), `"route":"export_ndjson"`) {
+		t.Errorf("expected the aborted export to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,96 @@
+// Package signedurl mints and verifies pre-authorized search URLs.
+//
+// A signed URL carries an expires parameter, holding a Unix timestamp, and a
+// signature parameter holding an HMAC-SHA256 over the URL's path and all of
+// its other parameters. Changing the path, or adding, removing or changing a
+// parameter, invalidates the signature.
+//
+// A backend holding the secret mints URLs with Sign and hands them to
+// browsers; the search proxy checks them with Verify.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// The parameter holding the expiry time as a Unix timestamp.
+	ExpiresParam = "expires"
+
+	// The parameter holding the signature.
+	SignatureParam = "signature"
+)
+
+var (
+	// Returned by Verify when the URL is not signed.
+	ErrMissing = errors.New("signedurl: the URL is not signed")
+
+	// Returned by Verify when the URL's expiry time has passed.
+	ErrExpired = errors.New("signedurl: the URL has expired")
+
+	// Returned by Verify when the signature does not match.
+	ErrInvalid = errors.New("signedurl: the signature is not valid")
+)
+
+// Returns rawURL with expires and signature parameters added, so that it is
+// valid until the given time. Any existing signature is replaced.
+func Sign(secret []byte, rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	params := u.Query()
+	params.Del(SignatureParam)
+	params.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	params.Set(SignatureParam, signature(secret, u.Path, params))
+
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// Checks the signature of a request with the given path and parameters.
+func Verify(secret []byte, path string, params url.Values, now time.Time) error {
+	sig := params.Get(SignatureParam)
+	if sig == "" {
+		return ErrMissing
+	}
+
+	expires, err := strconv.ParseInt(params.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	expected := signature(secret, path, params)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalid
+	}
+
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Returns the signature of a path and parameters, ignoring any signature
+// parameter.
+func signature(secret []byte, path string, params url.Values) string {
+	unsigned := url.Values{}
+	for k, v := range params {
+		if k != SignatureParam {
+			unsigned[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'?'})
+	mac.Write([]byte(unsigned.Encode()))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package signedurl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+var secret = []byte("secret")
+
+func verify(t *testing.T, rawURL string, now time.Time) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Verify(secret, u.Path, u.Query(), now)
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	signed, err := Sign(secret, "https://search.example.com/products?query=name:ada&limit=5", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(t, signed, now); err != nil {
+		t.Errorf("expected the signed URL to verify: %s", err)
+	}
+	if err := verify(t, signed, now.Add(2*time.Hour)); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+	if err := verify(t, "https://search.example.com/products?query=name:ada", now); err != ErrMissing {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+	if err := Verify([]byte("other"), "/products", mustQuery(t, signed), now); err != ErrInvalid {
+		t.Errorf("expected a different secret to be rejected, got %v", err)
+	}
+
+	tampered := mustQuery(t, signed)
+	tampered.Set("limit", "100")
+	if err := Verify(secret, "/products", tampered, now); err != ErrInvalid {
+		t.Errorf("expected a changed parameter to be rejected, got %v", err)
+	}
+
+	added := mustQuery(t, signed)
+	added.Set("offset", "10")
+	if err := Verify(secret, "/products", added, now); err != ErrInvalid {
+		t.Errorf("expected an added parameter to be rejected, got %v", err)
+	}
+
+	if err := Verify(secret, "/private", mustQuery(t, signed), now); err != ErrInvalid {
+		t.Errorf("expected a changed path to be rejected, got %v", err)
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/hoisie/web"
+	"orchestrate-heroku-search/signedurl"
+	"time"
+)
+
+// Reports whether a request carries a valid signature. A request whose
+// signature does not verify, or has expired, is refused with a 403 rather
+// than treated as unsigned.
+func checkSignature(ctx *web.Context) (bool, *apiError) {
+	params := ctx.Request.URL.Query()
+	if params.Get(signedurl.SignatureParam) == "" {
+		return false, nil
+	}
+
+	if conf.SigningSecret == "" {
+		return false, newAPIError(403, "invalid_signature", "Signed URLs are not accepted.")
+	}
+
+	switch signedurl.Verify([]byte(conf.SigningSecret), ctx.Request.URL.Path, params, time.Now()) {
+	case nil:
+		return true, nil
+	case signedurl.ErrExpired:
+		return false, newAPIError(403, "signature_expired", "The signed URL has expired.")
+	}
+	return false, newAPIError(403, "invalid_signature", "The URL signature is not valid.")
+}
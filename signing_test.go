@@ -0,0 +1,50 @@
+package main
+
+import (
+	"orchestrate-heroku-search/signedurl"
+	"testing"
+	"time"
+)
+
+const signedConfig = `{
+	"collections": {"people": {}, "reports": {"signed_only": true}},
+	"tiers": {"anonymous": {"collections": ["people"]}},
+	"signing_secret": "secret"
+}`
+
+func TestSearchSignedOnly(t *testing.T) {
+	p := newTestProxy(t, signedConfig)
+	defer p.Close()
+	p.orchestrate.Put("reports", "q1", map[string]interface{}{"title": "Q1"})
+
+	w := p.get("/reports?query=q1", nil)
+	if w.Code != 403 {
+		t.Fatalf("expected unsigned requests to be refused, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "signature_required" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	signed, err := signedurl.Sign([]byte("secret"), "/reports?query=q1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := p.get(signed, nil); w.Code != 200 {
+		t.Errorf("expected the signed URL to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := p.get(signed+"&limit=100", nil); w.Code != 403 || decodeError(t, w).Code != "invalid_signature" {
+		t.Errorf("expected a tampered URL to be refused, got %d", w.Code)
+	}
+
+	expired, _ := signedurl.Sign([]byte("secret"), "/reports?query=q1", time.Now().Add(-time.Minute))
+	if w := p.get(expired, nil); w.Code != 403 || decodeError(t, w).Code != "signature_expired" {
+		t.Errorf("expected an expired URL to be refused, got %d", w.Code)
+	}
+}
+
+func TestSignedOnlyRequiresSecret(t *testing.T) {
+	if _, err := parseConfig([]byte(`{"collections": {"reports": {"signed_only": true}}}`)); err == nil {
+		t.Error("expected signed_only without a signing_secret to be rejected")
+	}
+}
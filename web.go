@@ -1,53 +1,113 @@
 package main
 
 import (
-	"bytes"
-	"github.com/orchestrate-io/gorc"
-	"encoding/json"
+	"context"
 	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 var (
-	c = gorc.NewClient(os.Getenv("ORC_KEY"))
+	c         *gorc.Client
+	conf      *config
+	responses *responseCache
+	limiter   limiterStore
+	keys      *keyring
+
+	readinessChecker *readinessCheck
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	log.Printf("Listening on port %v ...", port)
-	web.Get("/([^/]+/?)", search)
-	web.Run(":" + port)
-}
-
-func search(ctx *web.Context, collection string) {
-	ctx.ContentType("json")
-	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
-
-	query := ctx.Params["query"]
-
-	var limit, offset int64
 	var err error
+	if c, err = newClient(); err != nil {
+		log.Fatal(err)
+	}
+	newConf, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	configure(newConf)
+	if len(conf.Collections) == 0 {
+		logInfo(nil, "No collections are configured; every search will 404.")
+	}
+	if len(conf.anonymousGated) > 0 {
+		logInfo(nil, "No anonymous tier is configured, so anonymous callers can't search %s, which other tiers name.", strings.Join(conf.anonymousGated, ", "))
+	}
 
-	if limit, err = strconv.ParseInt(ctx.Params["limit"], 10, 32); err != nil {
-		limit = 10
+	if conf.KeysCollection != "" {
+		if err := keys.load(c, conf.KeysCollection); err != nil {
+			logError(nil, "Failed to load API keys: %s", err)
+		}
+		go keys.watch(c, conf.KeysCollection, conf.KeysRefresh.Duration)
 	}
-	if offset, err = strconv.ParseInt(ctx.Params["offset"], 10, 32); err != nil {
-		offset = 0
+
+	// The listener is opened before signals are handled, so that shutdown
+	// always has one to close.
+	port := os.Getenv("PORT")
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	results, err := c.Search(collection, query, int(limit), int(offset))
+	drained := make(chan struct{})
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		logInfo(nil, "Received %s", <-stop)
+		shutdown(l, conf.ShutdownGrace.Duration)
+		close(drained)
+	}()
 
-	buf := new(bytes.Buffer)
-	encoder := json.NewEncoder(buf)
+	logInfo(nil, "Listening on port %v ...", port)
+	http.Serve(l, newServer())
+	<-drained
+}
 
-	if err != nil {
-		encoder.Encode(err)
-		ctx.WriteHeader(err.(*gorc.OrchestrateError).StatusCode)
-	} else {
-		encoder.Encode(results)
+// Installs a configuration along with the state derived from it.
+func configure(newConf *config) {
+	conf = newConf
+	responses = newResponseCache(conf.CacheSize)
+	limiter = newMemoryLimiterStore()
+	keys = newKeyring(conf.Keys)
+	logger = newLogger(os.Stdout, conf.Log)
+	upstreamBreaker = nil
+	if !conf.Breaker.Disabled {
+		upstreamBreaker = newCircuitBreaker(conf.Breaker)
 	}
+	readinessChecker = newReadinessCheck(func(ctx context.Context) error { return c.PingContext(ctx) }, conf.ReadyTimeout.Duration, conf.ReadyCacheTTL.Duration)
+}
+
+// Matches a collection name in a route. Names starting with an underscore
+// are reserved for the proxy's own endpoints, so collection routes never
+// shadow them.
+const collectionRoute = `([^/_][^/]*)`
 
-	ctx.Write(buf.Bytes())
+// Returns a web server with the proxy's routes registered.
+func newServer() *web.Server {
+	s := web.NewServer()
+	// Requests are logged by instrument, and crashes by untrackRequest, so
+	// the server's own log would only repeat them.
+	s.Logger = log.New(ioutil.Discard, "", 0)
+	s.Get("/_health", instrumentRoute("health", health))
+	s.Get("/_ready", instrumentRoute("ready", ready))
+	s.Get("/metrics", instrumentRoute("metrics", serveMetrics))
+	s.Get("/"+collectionRoute+`\.csv`, instrument("export_csv", exportCSV))
+	s.Get("/"+collectionRoute+`\.ndjson`, instrument("export_ndjson", exportNDJSON))
+	s.Get("/"+collectionRoute+`\.atom`, instrument("feed", searchFeed))
+	s.Get("/"+collectionRoute+`/opensearch\.xml`, instrument("opensearch", openSearch))
+	s.Get("/"+collectionRoute+"/?", instrument("search", search))
+	s.Get("/.*", instrumentRoute("not_found", notFound))
+	return s
+}
+
+func notFound(ctx *web.Context) {
+	ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+	writeError(ctx, errNotFound())
 }
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/orchestrate-io/gorc"
+	"github.com/orchestrate-io/gorc/gorctest"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A proxy wired to an in-memory Orchestrate.
+type testProxy struct {
+	orchestrate *gorctest.Server
+	server      *web.Server
+}
+
+// Starts a proxy with the given configuration document, backed by a fresh
+// in-memory Orchestrate.
+func newTestProxy(t *testing.T, configJSON string) *testProxy {
+	newConf, err := parseConfig([]byte(configJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configure(newConf)
+
+	orchestrate := gorctest.NewServer()
+	c = orchestrate.Client()
+
+	server := newServer()
+	server.Logger = log.New(ioutil.Discard, "", 0)
+
+	return &testProxy{orchestrate: orchestrate, server: server}
+}
+
+func (p *testProxy) Close() {
+	p.orchestrate.Close()
+}
+
+// Performs a GET against the proxy.
+func (p *testProxy) get(path string, header http.Header) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		panic(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return p.do(req)
+}
+
+// Sends a request to the proxy.
+func (p *testProxy) do(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.server.ServeHTTP(w, req)
+	return w
+}
+
+// Decodes an error envelope, failing the test if the response is not one.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) *apiError {
+	var envelope errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error == nil {
+		t.Fatalf("expected an error envelope, got %q", w.Body.String())
+	}
+	return envelope.Error
+}
+
+// Decodes search results, failing the test if the response holds none.
+func decodeResults(t *testing.T, w *httptest.ResponseRecorder) *gorc.SearchResults {
+	results := new(gorc.SearchResults)
+	if err := json.Unmarshal(w.Body.Bytes(), results); err != nil {
+		t.Fatalf("expected search results, got %q", w.Body.String())
+	}
+	return results
+}
+
+const testConfig = `{
+	"collections": {
+		"people": {"default_limit": 2, "max_limit": 3, "default_query": "active:true", "cache_ttl": "1m",
+			"cache_control": "public, max-age=5", "vary": ["Origin", "Accept-Encoding"]},
+		"limited": {"rate_limit": {"requests": 2, "per": "1h"}},
+		"guarded": {"query_rules": {"allowed_fields": ["name"]}, "redact": ["email"]},
+		"hidden": {"disabled": true}
+	}
+}`
+
+func seedPeople(p *testProxy) {
+	p.orchestrate.Put("people", "ada", map[string]interface{}{"name": "Ada", "active": true})
+	p.orchestrate.Put("people", "charles", map[string]interface{}{"name": "Charles", "active": true})
+	p.orchestrate.Put("people", "grace", map[string]interface{}{"name": "Grace", "active": true})
+	p.orchestrate.Put("people", "alan", map[string]interface{}{"name": "Alan", "active": false})
+	p.orchestrate.Put("hidden", "x", map[string]interface{}{"name": "Ada"})
+	p.orchestrate.Put("private", "x", map[string]interface{}{"name": "Ada"})
+}
+
+func TestSearch(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	w := p.get("/people?query=name:ada", nil)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("expected CORS header")
+	}
+
+	var results gorc.SearchResults
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if results.TotalCount != 1 || results.Results[0].Path.Key != "ada" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestSearchPolicyDefaults(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	for path, expected := range map[string]int{
+		"/people":           2,
+		"/people/":          2,
+		"/people?limit=1":   1,
+		"/people?limit=100": 3,
+	} {
+		var results gorc.SearchResults
+		w := p.get(path, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if int(results.Count) != expected || results.TotalCount != 3 {
+			t.Errorf("%s: expected %d of 3 active people, got %d of %d", path, expected, results.Count, results.TotalCount)
+		}
+	}
+}
+
+func TestSearchUnlistedCollection(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	for _, path := range []string{"/private?query=ada", "/hidden?query=ada"} {
+		w := p.get(path, nil)
+		if w.Code != 404 {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != "collection_not_found" || e.RequestID == "" {
+			t.Errorf("%s: unexpected error %+v", path, e)
+		}
+	}
+
+	if requests := p.orchestrate.Requests(); requests != 0 {
+		t.Errorf("expected no upstream requests, got %d", requests)
+	}
+}
+
+func TestSearchUpstreamErrors(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	w := p.get("/people?query=(unbalanced", http.Header{"X-Request-Id": {"abc-123"}})
+	if w.Code != 400 {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "invalid_query" || e.RequestID != "abc-123" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	p.orchestrate.Close()
+	w = p.get("/people?query=ada", nil)
+	if w.Code != 502 {
+		t.Fatalf("expected 502 once Orchestrate is unreachable, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "upstream_unavailable" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestSearchCache(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	first := p.get("/people?query=name:ada", nil)
+	second := p.get("/people?query=%20name:ada%20", nil)
+
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected MISS then HIT, got %q then %q", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Error("expected the cached body to match")
+	}
+	if requests := p.orchestrate.Requests(); requests != 1 {
+		t.Errorf("expected a single upstream request, got %d", requests)
+	}
+}
+
+func TestSearchDisconnect(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	// The client goes away while Orchestrate is searching, which cancels the
+	// call.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan struct{})
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		cancel()
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(time.Second):
+		}
+		return true
+	}
+	req, _ := http.NewRequest("GET", "/people?query=ada", nil)
+	if w := p.do(req.WithContext(ctx)); w.Code != 499 {
+		t.Errorf("expected the search to be abandoned, got %d", w.Code)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to Orchestrate to be canceled")
+	}
+
+	// Nothing was cached, so the next search calls Orchestrate again.
+	p.orchestrate.Intercept = nil
+	if w := p.get("/people?query=ada", nil); w.Code != 200 || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expected a fresh search, got %d %q", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestSearchStale(t *testing.T) {
+	p := newTestProxy(t, `{"collections": {
+		"people": {"cache_ttl": "10ms", "max_stale": "1h"},
+		"strict": {"cache_ttl": "10ms"}
+	}}`)
+	defer p.Close()
+	seedPeople(p)
+	p.orchestrate.Put("strict", "ada", map[string]interface{}{"name": "Ada"})
+
+	cached := p.get("/people?query=ada", nil)
+	p.get("/strict?query=ada", nil)
+	time.Sleep(20 * time.Millisecond)
+
+	status := 503
+	p.orchestrate.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(status)
+		return true
+	}
+
+	w := p.get("/people?query=ada", nil)
+	if w.Code != 200 || w.Body.String() != cached.Body.String() {
+		t.Fatalf("expected the last good response, got %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Cache") != "STALE" || w.Header().Get("Warning") != `110 - "Response is Stale"` || w.Header().Get("X-Stale-Age") != "0" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+
+	if w := p.get("/people?query=charles", nil); w.Code != 502 {
+		t.Errorf("expected a search that was never cached to fail, got %d", w.Code)
+	}
+	if w := p.get("/strict?query=ada", nil); w.Code != 502 {
+		t.Errorf("expected a collection without max_stale to fail, got %d", w.Code)
+	}
+
+	// Client errors are passed on rather than hidden.
+	status = 400
+	if w := p.get("/people?query=ada", nil); w.Code != 400 {
+		t.Errorf("expected an Orchestrate 400 to be reported, got %d", w.Code)
+	}
+}
+
+func TestSearchConditional(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+
+	w := p.get("/people?query=name:ada", nil)
+	tag := w.Header().Get("ETag")
+	if tag == "" || w.Header().Get("Cache-Control") != "public, max-age=5" || w.Header().Get("Vary") != "Origin, Accept-Encoding" {
+		t.Fatalf("unexpected caching headers %v", w.Header())
+	}
+
+	w = p.get("/people?query=name:ada", http.Header{"If-None-Match": {`"other", W/` + tag}})
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("expected an empty 304, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != tag {
+		t.Error("expected the 304 to carry the ETag")
+	}
+
+	w = p.get("/people?query=name:charles", http.Header{"If-None-Match": {tag}})
+	if w.Code != 200 || w.Header().Get("ETag") == tag {
+		t.Errorf("expected a different body to be sent in full, got %d", w.Code)
+	}
+}
+
+func TestSearchRateLimit(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	seedPeople(p)
+	p.orchestrate.Put("limited", "x", map[string]interface{}{"name": "Ada"})
+
+	client := http.Header{"X-Forwarded-For": {"9.9.9.9, 1.2.3.4"}}
+	for i := 0; i < 2; i++ {
+		if w := p.get("/limited", client); w.Code != 200 {
+			t.Fatalf("expected request %d to be allowed, got %d", i, w.Code)
+		}
+	}
+
+	w := p.get("/limited", client)
+	if w.Code != 429 {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Errorf("unexpected rate limit headers %v", w.Header())
+	}
+	if e := decodeError(t, w); e.Code != "rate_limited" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	if w := p.get("/limited", http.Header{"X-Forwarded-For": {"1.2.3.5"}}); w.Code != 200 {
+		t.Errorf("expected another client to be allowed, got %d", w.Code)
+	}
+	if w := p.get("/people", client); w.Code != 200 {
+		t.Errorf("expected other collections to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestSearchQueryRules(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+	p.orchestrate.Put("guarded", "x", map[string]interface{}{"name": "Ada", "email": "ada@example.com"})
+
+	if w := p.get("/guarded?query=name:ada", nil); w.Code != 200 {
+		t.Errorf("expected an allowed field to be searchable, got %d", w.Code)
+	}
+	if w := p.get("/guarded", nil); w.Code != 200 {
+		t.Errorf("expected the default query to be trusted, got %d", w.Code)
+	}
+
+	w := p.get("/guarded?query=name:ada&fields=name,email", nil)
+	if body := w.Body.String(); !strings.Contains(body, `"value":{"name":"Ada"}`) {
+		t.Errorf("expected only the name to be returned, got %s", body)
+	}
+
+	w = p.get("/guarded?query=email:ada@example.com", nil)
+	if w.Code != 400 {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "field_not_allowed" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if requests := p.orchestrate.Requests(); requests != 3 {
+		t.Errorf("expected rejected queries not to reach Orchestrate, got %d requests", requests)
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	p := newTestProxy(t, testConfig)
+	defer p.Close()
+
+	w := p.get("/people/ada/refs", nil)
+	if w.Code != 404 {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != "not_found" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}